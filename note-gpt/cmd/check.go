@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"note-gpt/internal"
+	"note-gpt/pkg"
+	"notescore"
+)
+
+// runConfig implements `note-gpt config check`, which validates the
+// configuration and then tests connectivity to every configured service
+func runConfig(args []string) int {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Println("usage: note-gpt config check [flags]")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("config check", flag.ContinueOnError)
+	var flags internal.Flags
+	flags.Register(fs)
+	flags.RegisterSources(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	config, err := internal.LoadConfig(flags)
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		return 1
+	}
+
+	checks := notescore.SourceChecks(config)
+	checks = append(checks, notescore.IndexChecks(config)...)
+	checks = append(checks, notescore.Check{Name: "llm", Probe: func(ctx context.Context) (string, error) {
+		llm, err := pkg.NewGeminiClient(config.LLM.APIKey, config.LLM.Model)
+		if err != nil {
+			return "", err
+		}
+		defer llm.Close()
+		if err := llm.Ping(ctx); err != nil {
+			return "", err
+		}
+		return config.LLM.Model, nil
+	}})
+	if !notescore.RunChecks(config, checks...) {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"note-gpt/internal"
+	"note-gpt/pkg"
+	"notescore"
+)
+
+const hashEmbeddingDimension = 768
+
+// runEval implements `note-gpt eval`, scoring retrieval against a golden
+// question set. By default it runs fully offline on an in-memory index of
+// the configured sources built with a hashing embedder.
+func runEval(args []string) int {
+	fs := flag.NewFlagSet("eval", flag.ContinueOnError)
+	questionsPath := fs.String("questions", "", "question set (.yaml, .yml or .jsonl)")
+	k := fs.Int("k", 5, "number of notes to score per question")
+	store := fs.String("store", "local", "vector store: local or pinecone")
+	embedder := fs.String("embedder", "hash", "embedder for the local store: hash or ollama")
+	generate := fs.Bool("generate", false, "also generate answers with the LLM")
+	judge := fs.Bool("judge", false, "rate answer faithfulness with the LLM (implies -generate)")
+	format := fs.String("format", "text", "report format: text or json")
+	out := fs.String("out", "", "write the report to this file instead of stdout")
+	baseline := fs.String("baseline", "", "JSON report of a previous run to compare against")
+	var flags internal.Flags
+	flags.Register(fs)
+	flags.RegisterSources(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *questionsPath == "" || *k < 1 {
+		fs.Usage()
+		return 2
+	}
+	if *judge {
+		*generate = true
+	}
+
+	questions, err := internal.LoadEvalQuestions(*questionsPath)
+	if err != nil {
+		fmt.Printf("Error loading questions: %v\n", err)
+		return 1
+	}
+
+	// Only the online parts of a run need API keys
+	load := internal.LoadLocalConfig
+	if *store == "pinecone" || *embedder == "ollama" || *generate {
+		load = internal.LoadConfig
+	}
+	config, err := load(flags)
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		return 1
+	}
+
+	var vectorStore internal.VectorStore
+	switch *store {
+	case "local":
+		// Task prefixes only mean something to real models
+		var emb notescore.Embedder = pkg.NewHashEmbedding(hashEmbeddingDimension)
+		input := notescore.Input{Title: config.Embedder.Title}
+		if *embedder == "ollama" {
+			emb = notescore.NewEmbedding(config.Embedder)
+			input = notescore.NewInput(config.Embedder)
+		} else if *embedder != "hash" {
+			fmt.Printf("Unknown embedder %q\n", *embedder)
+			return 2
+		}
+		local := pkg.NewLocalVector(emb, input)
+		for _, source := range config.AllSources() {
+			// Unsynced sources have no vault ID and resolve by name instead
+			vaultID, _ := notescore.ReadVaultID(source.Path)
+			if err := local.LoadNotes(context.Background(), source.Path, vaultID, source.Name); err != nil {
+				fmt.Printf("Error indexing source %s: %v\n", source.Name, err)
+				return 1
+			}
+		}
+		vectorStore = local
+	case "pinecone":
+		embedding := notescore.NewEmbedding(config.Embedder)
+		vectorDb, err := notescore.NewVector(config.VectorStore.APIKey, config.VectorStore.Host, config.VectorStore.Index, embedding)
+		if err != nil {
+			fmt.Printf("Error initializing vector database: %v\n", err)
+			return 1
+		}
+		namespaces, _ := config.Namespaces(config.Retrieval.Sources)
+		vectorStore = vectorDb.WithNamespaces(namespaces)
+	default:
+		fmt.Printf("Unknown store %q\n", *store)
+		return 2
+	}
+
+	var llm *pkg.GeminiClient
+	if *generate {
+		llm, err = pkg.NewGeminiClient(config.LLM.APIKey, config.LLM.Model)
+		if err != nil {
+			fmt.Printf("Error initializing Gemini client: %v\n", err)
+			return 1
+		}
+		defer llm.Close()
+	}
+
+	app := internal.NewApp(vectorStore, llm, config)
+	report := app.RunEval(context.Background(), questions, internal.EvalOptions{
+		K:        *k,
+		Generate: *generate,
+		Judge:    *judge,
+		Config: map[string]string{
+			"store":    *store,
+			"embedder": *embedder,
+			"k":        strconv.Itoa(*k),
+			"chunk":    strconv.Itoa(notescore.MaxChunkChars),
+			"graph":    strconv.Itoa(config.Retrieval.GraphDepth),
+		},
+	})
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Printf("Error creating report: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	if *format == "json" {
+		if err := report.WriteJSON(w); err != nil {
+			fmt.Printf("Error writing report: %v\n", err)
+			return 1
+		}
+	} else {
+		report.WriteText(w)
+	}
+
+	if *baseline != "" {
+		base, err := internal.LoadEvalReport(*baseline)
+		if err != nil {
+			fmt.Printf("Error loading baseline: %v\n", err)
+			return 1
+		}
+		fmt.Println()
+		report.WriteComparison(os.Stdout, base)
+	}
+	return 0
+}
@@ -9,31 +9,55 @@ import (
 
 	"note-gpt/internal"
 	"note-gpt/pkg"
+	"notescore"
 )
 
+// relatedNotes is how many notes /related lists
+const relatedNotes = 10
+
 func main() {
-	config, err := internal.LoadConfig()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "eval":
+			os.Exit(runEval(os.Args[2:]))
+		case "config":
+			os.Exit(runConfig(os.Args[2:]))
+		case "related":
+			os.Exit(runRelated(os.Args[2:]))
+		case "map":
+			os.Exit(runMap(os.Args[2:]))
+		}
+	}
+
+	var flags internal.Flags
+	flags.Register(flag.CommandLine)
+	flags.RegisterSources(flag.CommandLine)
+	flag.Parse()
+
+	config, err := internal.LoadConfig(flags)
 	if err != nil {
-		fmt.Println("Failed to load configuration. Exiting.")
-		return
+		fmt.Printf("Error loading config: %v\n", err)
+		os.Exit(1)
 	}
-	geminiClient, err := pkg.NewGeminiClient(config.GeminiAPIKey)
+	geminiClient, err := pkg.NewGeminiClient(config.LLM.APIKey, config.LLM.Model)
 	if err != nil {
 		fmt.Printf("Error initializing Gemini client: %v\n", err)
-		return
+		os.Exit(1)
 	}
 	defer geminiClient.Close()
 
-	vectorDb, err := pkg.NewVector(config.PineconeAPIKey, config.PineconeHost, "notes-index", config.EmbeddingUrl)
+	embedder := notescore.NewEmbedding(config.Embedder)
+	vectorDb, err := notescore.NewVector(config.VectorStore.APIKey, config.VectorStore.Host, config.VectorStore.Index, embedder)
 	if err != nil {
 		fmt.Printf("Error initializing vector database: %v\n", err)
-		return
+		os.Exit(1)
 	}
 
+	namespaces, _ := config.Namespaces(config.Retrieval.Sources)
+
 	// Interactive mode
-	flag.Parse()
 	fmt.Println("Welcome to note-gpt! Type 'exit' to quit.")
-	app := internal.NewApp(vectorDb, geminiClient)
+	app := internal.NewApp(vectorDb.WithNamespaces(namespaces), geminiClient, config)
 	scanner := bufio.NewScanner(os.Stdin)
 
 	for {
@@ -52,6 +76,40 @@ func main() {
 			continue
 		}
 
+		if input == "/sources" {
+			printSources(app)
+			continue
+		}
+
+		if input == "/tags" {
+			printTags(app)
+			continue
+		}
+
+		if input == "/tag" || strings.HasPrefix(input, "/tag ") {
+			printTagged(app, strings.TrimSpace(strings.TrimPrefix(input, "/tag")))
+			continue
+		}
+
+		if input == "/related" || strings.HasPrefix(input, "/related ") {
+			if path := strings.TrimSpace(strings.TrimPrefix(input, "/related")); path != "" {
+				printRelated(app, path, relatedNotes)
+			} else {
+				fmt.Println("usage: /related <path>")
+			}
+			continue
+		}
+
+		if input == "/save" || strings.HasPrefix(input, "/save ") {
+			saveAnswer(app, strings.TrimSpace(strings.TrimPrefix(input, "/save")))
+			continue
+		}
+
+		if input == "/use" || strings.HasPrefix(input, "/use ") {
+			useSources(app, vectorDb, config, strings.TrimSpace(strings.TrimPrefix(input, "/use")))
+			continue
+		}
+
 		response, err := app.HandleQuery(input)
 		if err != nil {
 			fmt.Printf("Error handling query: %v\n", err)
@@ -60,3 +118,101 @@ func main() {
 		fmt.Println(response)
 	}
 }
+
+// printSources shows the search queries and files used for the last answer
+func printSources(app *internal.App) {
+	turn, ok := app.LastTurn()
+	if !ok {
+		fmt.Println("No query has been answered yet.")
+		return
+	}
+
+	fmt.Println("Search queries:")
+	for i, q := range turn.Queries {
+		fmt.Printf("  %d. %s\n", i+1, q)
+	}
+	fmt.Println("Sources:")
+	for _, source := range turn.Sources {
+		fmt.Printf("  [%.3f] %s (lines %d-%d)", source.Score, source.FilePath, source.StartLine, source.EndLine)
+		if source.Mention != "" {
+			fmt.Printf("  mentioned by %s", source.Mention)
+		}
+		if source.Via != "" {
+			fmt.Printf("  graph: %s, %d hop(s)", source.Via, source.Depth)
+		}
+		fmt.Println()
+	}
+}
+
+// printTags lists the tags of the queried sources, most used first
+func printTags(app *internal.App) {
+	tags := app.Tags()
+	if len(tags) == 0 {
+		fmt.Println("No tags found. Tags are read by vector-sync.")
+		return
+	}
+	for _, tag := range tags {
+		fmt.Printf("  #%s (%d)\n", tag.Tag, tag.Notes)
+	}
+}
+
+// printTagged lists the notes with a tag, e.g. "/tag project" also lists
+// notes tagged #project/alpha
+func printTagged(app *internal.App, tag string) {
+	if tag == "" {
+		fmt.Println("usage: /tag <name>")
+		return
+	}
+	notes := app.Tagged(tag)
+	if len(notes) == 0 {
+		fmt.Printf("No notes tagged #%s\n", notescore.NormalizeTag(tag))
+		return
+	}
+	for _, note := range notes {
+		fmt.Printf("  %s  (%s)\n", note.FilePath, note.Source)
+	}
+}
+
+// saveAnswer writes the last answer into the vault as a note, named title or
+// after the question
+func saveAnswer(app *internal.App, title string) {
+	path, err := app.SaveAnswer(title)
+	if err != nil {
+		fmt.Printf("Error saving the answer: %v\n", err)
+		return
+	}
+	fmt.Printf("Saved to %s\n", path)
+}
+
+// useSources switches which sources are queried, e.g. "/use personal,team"
+// or "/use all". Without arguments it lists the sources.
+func useSources(app *internal.App, vectorDb *notescore.Vector, config *internal.Config, list string) {
+	if list == "" {
+		selected := make(map[string]bool)
+		for _, name := range config.Retrieval.Sources {
+			selected[name] = true
+		}
+		for _, source := range config.AllSources() {
+			marker := " "
+			if len(selected) == 0 || selected[source.Name] {
+				marker = "*"
+			}
+			fmt.Printf("%s %s (%s)\n", marker, source.Name, source.Path)
+		}
+		return
+	}
+
+	names := notescore.ParseSourceList(list)
+	namespaces, err := config.Namespaces(names)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	config.Retrieval.Sources = names
+	app.Vector = vectorDb.WithNamespaces(namespaces)
+	if len(names) == 0 {
+		fmt.Println("Querying all sources.")
+	} else {
+		fmt.Printf("Querying %s.\n", strings.Join(names, ", "))
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"note-gpt/internal"
+	"note-gpt/pkg"
+	"notescore"
+)
+
+// runMap implements `note-gpt map`, which groups the notes of each searched
+// source into topics and writes a map of them, linking every note, into the
+// vault
+func runMap(args []string) int {
+	fs := flag.NewFlagSet("map", flag.ContinueOnError)
+	topics := fs.Int("topics", 0, "number of topics to group the notes into, 0 to pick one from the number of notes")
+	note := fs.String("note", "Vault Map.md", "vault-relative path of the map note")
+	dryRun := fs.Bool("dry-run", false, "print the map instead of writing it")
+	noLLM := fs.Bool("no-llm", false, "name topics after their tags and notes instead of asking the LLM")
+	var flags internal.Flags
+	flags.Register(fs)
+	flags.RegisterSources(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: note-gpt map [-topics n] [-note path] [-dry-run] [-no-llm] [flags]")
+		fmt.Fprintln(fs.Output(), "\nThe generated part of the map note is replaced on every run, anything written around it is kept.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 || *topics < 0 || *note == "" {
+		fs.Usage()
+		return 2
+	}
+	notePath := filepath.ToSlash(filepath.Clean(*note))
+	if !strings.HasSuffix(notePath, ".md") {
+		notePath += ".md"
+	}
+
+	load := internal.LoadConfig
+	if *noLLM {
+		load = internal.LoadIndexConfig
+	}
+	config, err := load(flags)
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		return 1
+	}
+	var llm *pkg.GeminiClient
+	if !*noLLM {
+		llm, err = pkg.NewGeminiClient(config.LLM.APIKey, config.LLM.Model)
+		if err != nil {
+			fmt.Printf("Error initializing Gemini client: %v\n", err)
+			return 1
+		}
+		defer llm.Close()
+	}
+	embedder := notescore.NewEmbedding(config.Embedder)
+	vectorDb, err := notescore.NewVector(config.VectorStore.APIKey, config.VectorStore.Host, config.VectorStore.Index, embedder)
+	if err != nil {
+		fmt.Printf("Error initializing vector database: %v\n", err)
+		return 1
+	}
+	defer vectorDb.Close()
+
+	app := internal.NewApp(vectorDb, llm, config)
+	maps, err := app.VaultMaps(context.Background(), internal.MapOptions{Topics: *topics, Note: notePath})
+	if err != nil {
+		fmt.Printf("Error building the map: %v\n", err)
+		return 1
+	}
+	for _, m := range maps {
+		if *dryRun {
+			fmt.Printf("Map of %s (%s):\n\n%s\n", m.Source.Name, m.Note, m.Render())
+			continue
+		}
+		changed, err := m.Write()
+		if err != nil {
+			fmt.Printf("Error writing the map of %s: %v\n", m.Source.Name, err)
+			return 1
+		}
+		status := "unchanged"
+		if changed {
+			status = "updated"
+		}
+		fmt.Printf("%s: %s %s, %d topics from %d notes\n", m.Source.Name, status, filepath.Join(m.Source.Path, filepath.FromSlash(m.Note)), len(m.Topics), m.Notes)
+	}
+	return 0
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"note-gpt/internal"
+	"notescore"
+)
+
+// runRelated implements `note-gpt related <path>`, which lists the notes most
+// like a synced note without asking a question
+func runRelated(args []string) int {
+	fs := flag.NewFlagSet("related", flag.ContinueOnError)
+	k := fs.Int("k", 10, "number of related notes to list")
+	var flags internal.Flags
+	flags.Register(fs)
+	flags.RegisterSources(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: note-gpt related [-k n] [flags] <path>")
+		fmt.Fprintln(fs.Output(), "\nThe path is absolute or relative to a source, the .md extension may be left out.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 || *k < 1 {
+		fs.Usage()
+		return 2
+	}
+
+	config, err := internal.LoadIndexConfig(flags)
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		return 1
+	}
+	embedder := notescore.NewEmbedding(config.Embedder)
+	vectorDb, err := notescore.NewVector(config.VectorStore.APIKey, config.VectorStore.Host, config.VectorStore.Index, embedder)
+	if err != nil {
+		fmt.Printf("Error initializing vector database: %v\n", err)
+		return 1
+	}
+	defer vectorDb.Close()
+	namespaces, _ := config.Namespaces(config.Retrieval.Sources)
+
+	app := internal.NewApp(vectorDb.WithNamespaces(namespaces), nil, config)
+	if !printRelated(app, fs.Arg(0), *k) {
+		return 1
+	}
+	return 0
+}
+
+// printRelated lists the k notes most like the note at path and reports
+// whether it could
+func printRelated(app *internal.App, path string, k int) bool {
+	note, related, err := app.Related(context.Background(), path, k)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return false
+	}
+	fmt.Printf("Notes related to %s (source %s):\n", note.Path, note.Source)
+	if len(related) == 0 {
+		fmt.Println("  none found")
+	}
+	for i, r := range related {
+		fmt.Printf("  %2d. [%.3f] %s (%d matching chunks)\n", i+1, r.Score, r.FilePath, r.Matches)
+	}
+	return true
+}
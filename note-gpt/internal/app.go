@@ -4,81 +4,257 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"log/slog"
 	"note-gpt/pkg"
+	"notescore"
 	"path/filepath"
+	"strings"
 	"sync"
 
 	"github.com/pinecone-io/go-pinecone/v4/pinecone"
 )
 
+const rewriteHistoryTurns = 3
+
+// VectorStore is the similarity search App retrieves from. It is
+// implemented by notescore.Vector for Pinecone and pkg.LocalVector for
+// offline runs.
+type VectorStore interface {
+	EmbedQuery(ctx context.Context, queryText []byte) ([]float32, error)
+	QueryValues(ctx context.Context, values []float32, topK int) ([]*pinecone.ScoredVector, error)
+	QueryValuesNotes(ctx context.Context, values []float32, topK int, filter notescore.NoteFilter) ([]*pinecone.ScoredVector, error)
+	FetchFile(ctx context.Context, namespace, fileId string) ([]*pinecone.Vector, error)
+	FetchNamespace(ctx context.Context, namespace string) ([]*pinecone.Vector, error)
+}
+
 type App struct {
-	Vector              *pkg.Vector
+	Vector              VectorStore
 	LLM                 *pkg.GeminiClient
+	config              *Config
+	options             RetrievalOptions
+	vaults              map[string]SourceConfig // Configured sources by vault ID
 	conversationHistory []ConversationTurn
 	mu                  sync.RWMutex
+	tables              map[string]*tagTable // Tag tables by source name
+	tablesMu            sync.Mutex
 }
 
 type ConversationTurn struct {
-	Query    string
-	Response string
-	Context  []string // File contexts used for this turn
+	Query     string
+	Response  string
+	Context   []string      // File contexts used for this turn
+	Queries   []string      // Search queries used for retrieval, after rewriting
+	Sources   []FileContext // Files retrieved for this turn
+	Citations []Citation    // Sources cited in the response
 }
 
 type FileContext struct {
-	FilePath string
-	Content  string
-	Error    error
-	Score    float32
+	FilePath  string // Absolute path on this machine
+	Path      string // Relative to the vault root
+	Source    string // Name of the source the note belongs to
+	Content   string
+	Error     error
+	Score     float32
+	StartLine int // 1-based, inclusive
+	EndLine   int // 1-based, inclusive
+	Heading   string
+	VectorID  string
+	Mention   string // The alias or tag the query named the note by, if any
+	Via       string // How a linked note was reached, empty for direct matches
+	Depth     int    // Link hops from the direct matches
 }
 
-func NewApp(vector *pkg.Vector, llm *pkg.GeminiClient) *App {
+func NewApp(vector VectorStore, llm *pkg.GeminiClient, config *Config) *App {
+	options := retrievalOptions(config)
+	if options.FanOut < 1 {
+		options.FanOut = 1
+	}
+	// Vectors reference notes by vault ID, so map each configured source's
+	// ID back to it. Sources that were never synced have no ID yet.
+	vaults := make(map[string]SourceConfig)
+	for _, source := range config.AllSources() {
+		if id, err := notescore.ReadVaultID(source.Path); err == nil {
+			vaults[id] = source
+		}
+	}
 	return &App{
 		Vector:              vector,
 		LLM:                 llm,
+		config:              config,
+		options:             options,
+		vaults:              vaults,
 		conversationHistory: make([]ConversationTurn, 0),
+		tables:              make(map[string]*tagTable),
 	}
 }
 
 func (a *App) HandleQuery(query string) (string, error) {
 	ctx := context.Background()
 
-	// Query vector database for top 2 matches
-	matches, err := a.Vector.Query(ctx, []byte(query), 2)
+	// Retrieve the most relevant chunks across all rewritten queries
+	sources, queries, err := a.Retrieve(ctx, query, a.config.Retrieval.TopK)
 	if err != nil {
-		return "", fmt.Errorf("failed to query vector database: %w", err)
+		return "", err
 	}
 
-	// Read files concurrently
-	contexts := a.readFilesConcurrently(matches)
-
 	// Combine contexts for LLM
-	if len(contexts) == 0 {
+	if len(sources) == 0 {
 		return "No relevant files found for the query.", nil
 	}
 
-	systemPrompt := `You are a command-line LLM assistant. You are provided context from the user's local notes, which are synced every 30 seconds with a vector database. 
-For each query, only the top 2 relevant notes are retrieved and passed to you. 
+	response, _, err := a.generateAnswer(query, sources)
+	if err != nil {
+		return "", err
+	}
+
+	// Drop citation markers that don't refer to a provided passage
+	response, citations := a.resolveCitations(response, sources)
+
+	// Store this conversation turn
+	a.addConversationTurn(ConversationTurn{
+		Query:     query,
+		Response:  response,
+		Context:   formatContexts(sources),
+		Queries:   queries,
+		Sources:   sources,
+		Citations: citations,
+	})
+
+	return response + formatSourcesFooter(citations), nil
+}
+
+// Retrieve rewrites the query, searches the vector database and reads the
+// top k matching chunks. Notes the query names by alias or #tag are added,
+// and so are the notes they link to or are linked from when graph expansion
+// is on. It returns the chunks and the search queries used.
+func (a *App) Retrieve(ctx context.Context, query string, k int) ([]FileContext, []string, error) {
+	// Rewrite follow-ups into standalone search queries
+	queries := a.rewriteQuery(query)
+	var mentions []mention
+	if a.options.Mentions > 0 {
+		mentions = a.findMentions(query + "\n" + queries[0])
+		for i, q := range queries {
+			queries[i] = expandAliases(q, mentions)
+		}
+	}
+
+	// Query vector database for top matches across all queries
+	// The first query's embedding is reused to rank mentioned and linked notes
+	matches, queryValues, err := a.searchAll(ctx, queries, k)
+	if err != nil {
+		return nil, queries, fmt.Errorf("failed to query vector database: %w", err)
+	}
+	for _, match := range matches {
+		if match.Vector.Metadata == nil {
+			continue
+		}
+		if err := notescore.ParseChunkMetadata(match.Vector.Metadata.AsMap()).CheckSchema(); err != nil {
+			return nil, queries, err
+		}
+	}
+
+	var mentioned map[string]string
+	if len(mentions) > 0 {
+		extra, terms, err := a.mentionedMatches(ctx, queryValues, mentions, matches)
+		if err != nil {
+			slog.Warn("Looking up mentioned notes failed", notescore.KeyErr, err)
+		} else {
+			matches, mentioned = append(matches, extra...), terms
+		}
+	}
+
+	// Read files concurrently
+	sources := a.readFilesConcurrently(matches)
+	for i := range sources {
+		sources[i].Mention = mentioned[sources[i].VectorID]
+	}
+	if a.options.Graph.Depth > 0 {
+		linked, err := a.expandGraph(ctx, queryValues, matches)
+		if err != nil {
+			slog.Warn("Following links failed, using the direct matches only", notescore.KeyErr, err)
+		} else {
+			sources = a.addLinked(sources, linked)
+		}
+	}
+	return sources, queries, nil
+}
+
+// generateAnswer asks the LLM to answer query from sources. It returns the
+// raw response and the full prompt that was sent.
+func (a *App) generateAnswer(query string, sources []FileContext) (string, string, error) {
+	systemPrompt := fmt.Sprintf(`You are a command-line LLM assistant. You are provided context from the user's local notes, which are synced every 30 seconds with a vector database. 
+For each query, only the top %d relevant notes are retrieved and passed to you. 
 Based on the query and the provided context, answer concisely. 
 If the context does not contain an answer, say "I don't know." 
-Always cite the file names from which your information is taken, when relevant.
-You can refer to previous conversation turns when answering follow-up questions.`
+Each context passage is numbered like [1]. Cite the passages your information is taken from by writing their number in square brackets, e.g. [1], right after the sentence they support.
+Only cite numbers that appear in the provided context.
+You can refer to previous conversation turns when answering follow-up questions.`, a.config.Retrieval.TopK)
 
 	// Build conversation history for context
 	conversationContext := a.buildConversationContext()
 
 	combinedContext := fmt.Sprintf("<SYSTEM_PROMPT>\n%s\n</SYSTEM_PROMPT>\n\n%s<CURRENT_QUERY>\n%s\n</CURRENT_QUERY>\n\n<CURRENT_CONTEXT>\n%s\n</CURRENT_CONTEXT>\n\n",
-		systemPrompt, conversationContext, query, joinContexts(contexts))
+		systemPrompt, conversationContext, query, joinContexts(formatContexts(sources)))
 
 	response, err := a.LLM.GenerateResponse(combinedContext)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate LLM response: %w", err)
+		return "", combinedContext, fmt.Errorf("failed to generate LLM response: %w", err)
 	}
+	return response, combinedContext, nil
+}
 
-	// Store this conversation turn
-	a.addConversationTurn(query, response, contexts)
+// searchAll runs every query against the vector database and merges the
+// matches, keeping the best score per vector. It also returns the embedding
+// of the first query.
+func (a *App) searchAll(ctx context.Context, queries []string, k int) ([]*pinecone.ScoredVector, []float32, error) {
+	best := make(map[string]*pinecone.ScoredVector)
+	var order []string
+	var first []float32
+	for i, q := range queries {
+		values, err := a.Vector.EmbedQuery(ctx, []byte(q))
+		if err != nil {
+			return nil, nil, err
+		}
+		if i == 0 {
+			first = values
+		}
+		matches, err := a.Vector.QueryValues(ctx, values, k)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, match := range matches {
+			if match.Vector == nil {
+				continue
+			}
+			existing, ok := best[match.Vector.Id]
+			if !ok {
+				order = append(order, match.Vector.Id)
+			}
+			if !ok || match.Score > existing.Score {
+				best[match.Vector.Id] = match
+			}
+		}
+	}
 
-	fmt.Printf("Combined Context for LLM:\n%s\n", combinedContext)
-	return response, nil
+	merged := make([]*pinecone.ScoredVector, 0, len(order))
+	for _, id := range order {
+		merged = append(merged, best[id])
+	}
+	sortByScore(merged)
+	if len(merged) > k {
+		merged = merged[:k]
+	}
+	return merged, first, nil
+}
+
+func sortByScore(matches []*pinecone.ScoredVector) {
+	for i := 0; i < len(matches)-1; i++ {
+		for j := i + 1; j < len(matches); j++ {
+			if matches[i].Score < matches[j].Score {
+				matches[i], matches[j] = matches[j], matches[i]
+			}
+		}
+	}
 }
 
 func (a *App) buildConversationContext() string {
@@ -106,16 +282,10 @@ func (a *App) buildConversationContext() string {
 	return context
 }
 
-func (a *App) addConversationTurn(query, response string, contexts []string) {
+func (a *App) addConversationTurn(turn ConversationTurn) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	turn := ConversationTurn{
-		Query:    query,
-		Response: response,
-		Context:  contexts,
-	}
-
 	a.conversationHistory = append(a.conversationHistory, turn)
 
 	// Keep only last 5 turns to prevent context from growing too large
@@ -125,6 +295,29 @@ func (a *App) addConversationTurn(query, response string, contexts []string) {
 	}
 }
 
+// recentTurns returns up to n of the most recent conversation turns
+func (a *App) recentTurns(n int) []ConversationTurn {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	start := 0
+	if len(a.conversationHistory) > n {
+		start = len(a.conversationHistory) - n
+	}
+	turns := make([]ConversationTurn, len(a.conversationHistory)-start)
+	copy(turns, a.conversationHistory[start:])
+	return turns
+}
+
+// LastTurn returns the most recent conversation turn, if any
+func (a *App) LastTurn() (ConversationTurn, bool) {
+	turns := a.recentTurns(1)
+	if len(turns) == 0 {
+		return ConversationTurn{}, false
+	}
+	return turns[0], true
+}
+
 // ClearHistory clears the conversation history
 func (a *App) ClearHistory() {
 	a.mu.Lock()
@@ -142,7 +335,7 @@ func (a *App) GetConversationHistory() []ConversationTurn {
 	return history
 }
 
-func (a *App) readFilesConcurrently(matches []*pinecone.ScoredVector) []string {
+func (a *App) readFilesConcurrently(matches []*pinecone.ScoredVector) []FileContext {
 	var wg sync.WaitGroup
 	resultChan := make(chan FileContext, len(matches))
 
@@ -152,25 +345,32 @@ func (a *App) readFilesConcurrently(matches []*pinecone.ScoredVector) []string {
 			continue
 		}
 
-		metadata := match.Vector.Metadata.AsMap()
-		filePathVal := metadata["filepath"]
-		filePath, ok := filePathVal.(string)
+		metadata := notescore.ParseChunkMetadata(match.Vector.Metadata.AsMap())
+		source, relPath, ok := a.resolveNote(metadata)
 		if !ok {
+			slog.Warn("Skipping match from an unknown vault", notescore.KeyVectorID, match.Vector.Id)
 			continue
 		}
+		startLine, endLine, heading := metadata.StartLine, metadata.EndLine, metadata.Heading
 
 		wg.Add(1)
-		go func(path string, score float32) {
+		go func(source SourceConfig, relPath, id string, score float32) {
 			defer wg.Done()
 
+			path := filepath.Join(source.Path, relPath)
 			content, err := a.readFile(path)
-			resultChan <- FileContext{
+			fc := FileContext{
 				FilePath: path,
-				Content:  content,
+				Path:     filepath.ToSlash(relPath),
+				Source:   source.Name,
 				Error:    err,
 				Score:    score,
+				Heading:  heading,
+				VectorID: id,
 			}
-		}(filePath, match.Score)
+			fc.Content, fc.StartLine, fc.EndLine = sliceLines(content, startLine, endLine)
+			resultChan <- fc
+		}(source, relPath, match.Vector.Id, match.Score)
 	}
 
 	// Close channel when all goroutines complete
@@ -183,13 +383,18 @@ func (a *App) readFilesConcurrently(matches []*pinecone.ScoredVector) []string {
 	var fileContexts []FileContext
 	for result := range resultChan {
 		if result.Error != nil {
-			fmt.Printf("Warning: failed to read file %s: %v\n", result.FilePath, result.Error)
+			slog.Warn("Failed to read file", notescore.KeyPath, result.FilePath, notescore.KeyErr, result.Error)
 			continue
 		}
 		fileContexts = append(fileContexts, result)
 	}
 
-	// Sort by score (higher scores first - more relevant)
+	sortSources(fileContexts)
+	return fileContexts
+}
+
+// sortSources sorts by score, higher scores (more relevant) first
+func sortSources(fileContexts []FileContext) {
 	for i := 0; i < len(fileContexts)-1; i++ {
 		for j := i + 1; j < len(fileContexts); j++ {
 			if fileContexts[i].Score < fileContexts[j].Score {
@@ -197,16 +402,34 @@ func (a *App) readFilesConcurrently(matches []*pinecone.ScoredVector) []string {
 			}
 		}
 	}
+}
 
-	// Convert to string contexts
+// formatContexts converts file contexts to the numbered string form passed
+// to the LLM. Passage numbers are 1-based indexes into fileContexts.
+func formatContexts(fileContexts []FileContext) []string {
 	var contexts []string
-	for _, fc := range fileContexts {
-		contexts = append(contexts, fmt.Sprintf("File: %s\nContent:\n%s\n", fc.FilePath, fc.Content))
+	for i, fc := range fileContexts {
+		contexts = append(contexts, fmt.Sprintf("[%d] File: %s (lines %d-%d)\nContent:\n%s\n", i+1, fc.FilePath, fc.StartLine, fc.EndLine, fc.Content))
 	}
-
 	return contexts
 }
 
+// sliceLines returns the given 1-based inclusive line range of content. A zero
+// range, as stored for whole-file vectors, selects the entire file.
+func sliceLines(content string, start, end int) (string, int, int) {
+	lines := strings.Split(content, "\n")
+	if start < 1 {
+		start = 1
+	}
+	if end < start || end > len(lines) {
+		end = len(lines)
+	}
+	if start > end {
+		return "", start, end
+	}
+	return strings.Join(lines[start-1:end], "\n"), start, end
+}
+
 func (a *App) readFile(filePath string) (string, error) {
 	cleanPath := filepath.Clean(filePath)
 	content, err := ioutil.ReadFile(cleanPath)
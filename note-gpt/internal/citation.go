@@ -0,0 +1,214 @@
+package internal
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Citation is a numbered reference from a response to a retrieved passage
+type Citation struct {
+	Number    int
+	FilePath  string
+	StartLine int
+	EndLine   int
+	URI       string
+}
+
+// citationPattern matches [n] markers. Numbers of more than three digits,
+// like years, are never passage numbers.
+var citationPattern = regexp.MustCompile(` ?\[(\d{1,3})\]`)
+
+// resolveCitations validates the [n] markers in response against the
+// passages that were actually provided. Markers pointing at passages that
+// don't exist are removed. Brackets in code, and brackets right after a word
+// or another bracket, as in arr[1], are indexing rather than markers and are
+// left alone. Returns the cleaned response and the cited sources in order of
+// first appearance.
+func (a *App) resolveCitations(response string, sources []FileContext) (string, []Citation) {
+	var citations []Citation
+	seen := make(map[int]bool)
+
+	var cleaned strings.Builder
+	code := codeSpans(response)
+	last := 0       // End of the text copied to cleaned so far
+	markerEnd := -1 // End of the last marker, so that [1][2] are both markers
+	for _, match := range citationPattern.FindAllStringSubmatchIndex(response, -1) {
+		bracket := match[2] - 1
+		if inSpans(code, bracket) || !citationPosition(response, match[0], bracket, markerEnd) {
+			continue
+		}
+		markerEnd = match[1]
+		n, err := strconv.Atoi(response[match[2]:match[3]])
+		if err == nil && n >= 1 && n <= len(sources) {
+			if !seen[n] {
+				seen[n] = true
+				source := sources[n-1]
+				citations = append(citations, Citation{
+					Number:    n,
+					FilePath:  source.FilePath,
+					StartLine: source.StartLine,
+					EndLine:   source.EndLine,
+					URI:       a.citationURI(source),
+				})
+			}
+			continue
+		}
+		cleaned.WriteString(response[last:match[0]])
+		last = match[1]
+	}
+	cleaned.WriteString(response[last:])
+
+	return cleaned.String(), citations
+}
+
+// citationPosition reports whether the marker matched at start, with its
+// opening bracket at bracket, stands where a citation can: at the start of
+// the text or after a space or punctuation, but not directly after a word
+// character or a ']' other than that of the previous marker
+func citationPosition(text string, start, bracket, markerEnd int) bool {
+	if start < bracket || bracket == 0 {
+		return true
+	}
+	before, _ := utf8.DecodeLastRuneInString(text[:bracket])
+	if before == ']' {
+		return bracket == markerEnd
+	}
+	return before != '_' && !unicode.IsLetter(before) && !unicode.IsDigit(before)
+}
+
+// codeSpans returns the byte ranges of the fenced code blocks and inline code
+// spans of Markdown text, in order. An unclosed fence runs to the end of the
+// text; an unmatched backtick is just a backtick.
+func codeSpans(text string) [][2]int {
+	var spans [][2]int
+	fence := "" // Opening fence of the block we are in, if any
+	fenceStart := 0
+	proseStart := 0 // Start of the text since the last fence
+	for offset := 0; offset < len(text); {
+		end := strings.IndexByte(text[offset:], '\n') + 1
+		if end == 0 {
+			end = len(text) - offset
+		}
+		line := strings.TrimLeft(text[offset:offset+end], " ")
+		switch {
+		case fence == "":
+			if marker := fenceMarker(line); marker != "" {
+				spans = append(spans, inlineCodeSpans(text, proseStart, offset)...)
+				fence, fenceStart = marker, offset
+			}
+		case strings.HasPrefix(line, fence) && strings.TrimSpace(strings.TrimLeft(line, fence[:1])) == "":
+			spans = append(spans, [2]int{fenceStart, offset + end})
+			fence, proseStart = "", offset+end
+		}
+		offset += end
+	}
+	if fence != "" {
+		return append(spans, [2]int{fenceStart, len(text)})
+	}
+	return append(spans, inlineCodeSpans(text, proseStart, len(text))...)
+}
+
+// fenceMarker returns the run of three or more backticks or tildes line
+// opens a fenced code block with, or ""
+func fenceMarker(line string) string {
+	for _, c := range "`~" {
+		marker := strings.Repeat(string(c), 3)
+		if strings.HasPrefix(line, marker) {
+			return line[:len(line)-len(strings.TrimLeft(line, string(c)))]
+		}
+	}
+	return ""
+}
+
+// inlineCodeSpans returns the inline code spans of text[start:end]. A span
+// opens with a run of backticks and closes with the next run of the same
+// length.
+func inlineCodeSpans(text string, start, end int) [][2]int {
+	var spans [][2]int
+	for i := start; i < end; {
+		if text[i] != '`' {
+			i++
+			continue
+		}
+		run := i
+		for run < end && text[run] == '`' {
+			run++
+		}
+		ticks := text[i:run]
+		closing := -1
+		for j := run; j < end; {
+			k := strings.Index(text[j:end], ticks)
+			if k < 0 {
+				break
+			}
+			k += j
+			after := k + len(ticks)
+			if after == end || text[after] != '`' {
+				closing = k
+				break
+			}
+			for after < end && text[after] == '`' {
+				after++
+			}
+			j = after
+		}
+		if closing < 0 {
+			i = run
+			continue
+		}
+		spans = append(spans, [2]int{i, closing + len(ticks)})
+		i = closing + len(ticks)
+	}
+	return spans
+}
+
+// inSpans reports whether offset falls in one of spans
+func inSpans(spans [][2]int, offset int) bool {
+	for _, span := range spans {
+		if offset >= span[0] && offset < span[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// citationURI links to a note either as a file:// URI or, when configured, an
+// obsidian:// URI that opens it in the vault
+func (a *App) citationURI(source FileContext) string {
+	if a.config.Retrieval.CitationLinks == "obsidian" {
+		vault := filepath.Base(filepath.Dir(source.FilePath))
+		if config, ok := a.config.SourceNamed(source.Source); ok {
+			vault = filepath.Base(filepath.Clean(config.Path))
+		}
+		return fmt.Sprintf("obsidian://open?vault=%s&file=%s", queryEscape(vault), queryEscape(source.Path))
+	}
+	abs, err := filepath.Abs(source.FilePath)
+	if err != nil {
+		abs = source.FilePath
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String()
+}
+
+// queryEscape escapes s for use in a query string, using %20 for spaces as
+// Obsidian does not decode '+'
+func queryEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func formatSourcesFooter(citations []Citation) string {
+	if len(citations) == 0 {
+		return ""
+	}
+	var footer strings.Builder
+	footer.WriteString("\n\nSources:\n")
+	for _, c := range citations {
+		fmt.Fprintf(&footer, "[%d] %s, lines %d-%d\n    %s\n", c.Number, filepath.Base(c.FilePath), c.StartLine, c.EndLine, c.URI)
+	}
+	return strings.TrimSuffix(footer.String(), "\n")
+}
@@ -2,66 +2,98 @@ package internal
 
 import (
 	"fmt"
-	"os"
+	"notescore"
+	"path/filepath"
+)
 
-	"github.com/joho/godotenv"
+// The config file is shared with vector-sync, so its types live in notescore
+type (
+	Config       = notescore.Config
+	Flags        = notescore.Flags
+	SourceConfig = notescore.SourceConfig
 )
 
-type Config struct {
-	// Required API keys
-	PineconeAPIKey string
-	NotesDir       string
-	PineconeHost   string
-	EmbeddingUrl   string
-	GeminiAPIKey   string
+// LoadConfig loads the configuration note-gpt runs with, see
+// notescore.LoadConfig. Sources follow the index vector-sync last switched
+// them to.
+func LoadConfig(flags Flags) (*Config, error) {
+	return loadConfig(flags, notescore.IndexServices|notescore.LLMService)
 }
 
-// LoadConfig loads configuration from .env file and environment variables
-func LoadConfig() (*Config, error) {
-	// Try to load .env file (optional - don't fail if it doesn't exist)
-	if err := godotenv.Load(); err != nil {
-		fmt.Printf("Info: .env file not found, using environment variables only\n")
-	}
+// LoadIndexConfig is LoadConfig for commands that search the index without
+// asking the LLM, so a missing Gemini key is not reported
+func LoadIndexConfig(flags Flags) (*Config, error) {
+	return loadConfig(flags, notescore.IndexServices)
+}
 
-	config := &Config{
-		PineconeAPIKey: getEnvRequired("PINECONE_API_KEY"),
-		PineconeHost:   getEnvRequired("PINECONE_HOST"),
-		NotesDir:       getEnvRequired("NOTES_DIR"),
-		EmbeddingUrl:   os.Getenv("EMBEDDING_URL"),
-		GeminiAPIKey:   os.Getenv("GEMINI_API_KEY"),
-	}
+// LoadLocalConfig is LoadConfig for commands that run without the remote
+// services, so missing API keys and hosts are not reported
+func LoadLocalConfig(flags Flags) (*Config, error) {
+	return loadConfig(flags, 0)
+}
 
-	if err := config.validate(); err != nil {
-		return nil, fmt.Errorf("config validation failed: %w", err)
+func loadConfig(flags Flags, services notescore.Services) (*Config, error) {
+	config, err := notescore.LoadConfig(flags, services, validateRetrieval)
+	if err != nil {
+		return nil, err
 	}
-
+	applyActiveIndexes(config)
 	return config, nil
 }
 
-func (c *Config) validate() error {
-	if c.PineconeAPIKey == "" {
-		return fmt.Errorf("PINECONE_API_KEY is required")
+func validateRetrieval(c *Config) []error {
+	var errs []error
+	if _, err := c.Namespaces(c.Retrieval.Sources); err != nil {
+		errs = append(errs, fmt.Errorf("retrieval.sources: %w", err))
+	}
+	if c.Retrieval.TopK < 1 {
+		errs = append(errs, fmt.Errorf("retrieval.top_k (TOP_K) must be at least 1"))
+	}
+	if c.Retrieval.FanOut < 1 {
+		errs = append(errs, fmt.Errorf("retrieval.fan_out (QUERY_FANOUT) must be at least 1"))
 	}
-	if c.PineconeHost == "" {
-		return fmt.Errorf("PINECONE_HOST is required")
+	if c.Retrieval.CitationLinks != "file" && c.Retrieval.CitationLinks != "obsidian" {
+		errs = append(errs, fmt.Errorf("retrieval.citation_links (CITATION_LINKS) must be \"file\" or \"obsidian\""))
 	}
-	if c.NotesDir == "" {
-		return fmt.Errorf("NOTES_DIR is required")
+	if c.Retrieval.GraphDepth < 0 {
+		errs = append(errs, fmt.Errorf("retrieval.graph_depth (QUERY_GRAPH_DEPTH) must not be negative"))
 	}
-	if c.GeminiAPIKey == "" {
-		return fmt.Errorf("GEMINI_API_KEY is required")
+	if c.Retrieval.Mentions && c.Retrieval.MentionNotes < 1 {
+		errs = append(errs, fmt.Errorf("retrieval.mention_notes (QUERY_MENTION_NOTES) must be at least 1"))
 	}
-	if c.EmbeddingUrl == "" {
-		c.EmbeddingUrl = "http://localhost:8000/embed"
+	if !filepath.IsLocal(filepath.FromSlash(c.Retrieval.Inbox)) {
+		errs = append(errs, fmt.Errorf("retrieval.inbox (ANSWER_INBOX) must be a folder inside the vault"))
 	}
-	return nil
+	if c.Retrieval.GraphDepth > 0 {
+		if c.Retrieval.GraphNotes < 1 {
+			errs = append(errs, fmt.Errorf("retrieval.graph_notes (QUERY_GRAPH_NOTES) must be at least 1"))
+		}
+		if c.Retrieval.GraphDecay <= 0 || c.Retrieval.GraphDecay > 1 {
+			errs = append(errs, fmt.Errorf("retrieval.graph_decay (QUERY_GRAPH_DECAY) must be above 0 and at most 1"))
+		}
+		if c.Retrieval.ContextTokens < 1 {
+			errs = append(errs, fmt.Errorf("retrieval.context_tokens (CONTEXT_TOKENS) must be at least 1"))
+		}
+	}
+	return errs
 }
 
-// Helper function
-func getEnvRequired(key string) string {
-	value := os.Getenv(key)
-	if value == "" {
-		panic(fmt.Sprintf("Required environment variable %s is not set", key))
+// retrievalOptions returns the query options used by App
+func retrievalOptions(c *Config) RetrievalOptions {
+	mentions := 0
+	if c.Retrieval.Mentions {
+		mentions = c.Retrieval.MentionNotes
+	}
+	return RetrievalOptions{
+		Rewrite:  c.Retrieval.Rewrite,
+		HyDE:     c.Retrieval.HyDE,
+		FanOut:   c.Retrieval.FanOut,
+		Mentions: mentions,
+		Graph: GraphOptions{
+			Depth:  c.Retrieval.GraphDepth,
+			Notes:  c.Retrieval.GraphNotes,
+			Decay:  c.Retrieval.GraphDecay,
+			Budget: c.Retrieval.ContextTokens,
+		},
 	}
-	return value
 }
@@ -0,0 +1,345 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EvalQuestion is one entry of a golden question set
+type EvalQuestion struct {
+	ID       string   `json:"id" yaml:"id"`
+	Question string   `json:"question" yaml:"question"`
+	Expected []string `json:"expected" yaml:"expected"` // Note paths relative to their source directory
+}
+
+type EvalOptions struct {
+	K        int               // Number of distinct notes to score
+	Generate bool              // Also generate an answer for every question
+	Judge    bool              // Ask the LLM to rate answer faithfulness
+	Config   map[string]string // Description of the run, recorded in the report
+}
+
+type EvalResult struct {
+	ID             string   `json:"id"`
+	Question       string   `json:"question"`
+	Expected       []string `json:"expected"`
+	Retrieved      []string `json:"retrieved"`
+	Recall         float64  `json:"recall"`
+	ReciprocalRank float64  `json:"reciprocal_rank"`
+	NDCG           float64  `json:"ndcg"`
+	Answer         string   `json:"answer,omitempty"`
+	Faithfulness   *float64 `json:"faithfulness,omitempty"`
+	Error          string   `json:"error,omitempty"`
+}
+
+type EvalReport struct {
+	Config       map[string]string `json:"config"`
+	K            int               `json:"k"`
+	Questions    int               `json:"questions"`
+	Recall       float64           `json:"recall"`
+	MRR          float64           `json:"mrr"`
+	NDCG         float64           `json:"ndcg"`
+	Faithfulness *float64          `json:"faithfulness,omitempty"`
+	Results      []EvalResult      `json:"results"`
+}
+
+const judgePrompt = `You are grading an answer produced from the user's notes.
+Rate how faithful the answer is to the context: 1 means every claim in the answer is supported by the context, 0 means none is.
+Reply with a single number between 0 and 1 and nothing else.
+
+<CONTEXT>
+%s
+</CONTEXT>
+
+<ANSWER>
+%s
+</ANSWER>`
+
+// chunksPerNote is how many chunks are fetched per requested note so that
+// k distinct notes remain after chunks of the same note are merged
+const chunksPerNote = 3
+
+// LoadEvalQuestions reads a question set from a .yaml/.yml file holding a
+// list of questions, or a .jsonl file with one question per line
+func LoadEvalQuestions(path string) ([]EvalQuestion, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var questions []EvalQuestion
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &questions); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	case ".jsonl":
+		scanner := bufio.NewScanner(strings.NewReader(string(data)))
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" {
+				continue
+			}
+			var q EvalQuestion
+			if err := json.Unmarshal([]byte(text), &q); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+			questions = append(questions, q)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported question file %s, expected .yaml, .yml or .jsonl", path)
+	}
+
+	for i := range questions {
+		if questions[i].ID == "" {
+			questions[i].ID = fmt.Sprintf("q%d", i+1)
+		}
+		if questions[i].Question == "" {
+			return nil, fmt.Errorf("question %s has no text", questions[i].ID)
+		}
+	}
+	return questions, nil
+}
+
+// RunEval runs retrieval, and optionally generation, for every question and
+// scores the retrieved notes against the expected ones
+func (a *App) RunEval(ctx context.Context, questions []EvalQuestion, options EvalOptions) *EvalReport {
+	report := &EvalReport{
+		Config:    options.Config,
+		K:         options.K,
+		Questions: len(questions),
+	}
+
+	var faithfulnessSum float64
+	var judged int
+	for _, q := range questions {
+		// Questions are independent, so don't let one rewrite the next
+		a.ClearHistory()
+
+		result := EvalResult{ID: q.ID, Question: q.Question, Expected: q.Expected}
+		sources, _, err := a.Retrieve(ctx, q.Question, options.K*chunksPerNote)
+		if err != nil {
+			result.Error = err.Error()
+			report.Results = append(report.Results, result)
+			continue
+		}
+
+		result.Retrieved = a.rankNotes(sources, options.K)
+		result.Recall, result.ReciprocalRank, result.NDCG = scoreRanking(result.Retrieved, q.Expected, options.K)
+
+		if options.Generate && a.LLM != nil {
+			passages := sources
+			if len(passages) > a.config.Retrieval.TopK {
+				passages = passages[:a.config.Retrieval.TopK]
+			}
+			answer, _, err := a.generateAnswer(q.Question, passages)
+			if err != nil {
+				result.Error = err.Error()
+			} else {
+				result.Answer, _ = a.resolveCitations(answer, passages)
+				if options.Judge {
+					if score, err := a.judgeFaithfulness(result.Answer, passages); err != nil {
+						result.Error = err.Error()
+					} else {
+						result.Faithfulness = &score
+						faithfulnessSum += score
+						judged++
+					}
+				}
+			}
+		}
+
+		report.Recall += result.Recall
+		report.MRR += result.ReciprocalRank
+		report.NDCG += result.NDCG
+		report.Results = append(report.Results, result)
+	}
+
+	if n := float64(len(questions)); n > 0 {
+		report.Recall /= n
+		report.MRR /= n
+		report.NDCG /= n
+	}
+	if judged > 0 {
+		mean := faithfulnessSum / float64(judged)
+		report.Faithfulness = &mean
+	}
+	return report
+}
+
+// rankNotes merges chunk matches into a ranked list of at most k distinct
+// note paths relative to their source directory
+func (a *App) rankNotes(sources []FileContext, k int) []string {
+	var notes []string
+	seen := make(map[string]bool)
+	for _, source := range sources {
+		note := source.Path
+		if seen[note] {
+			continue
+		}
+		seen[note] = true
+		notes = append(notes, note)
+		if len(notes) == k {
+			break
+		}
+	}
+	return notes
+}
+
+// scoreRanking computes recall, reciprocal rank and binary-relevance nDCG of
+// a ranked list of at most k notes against the expected notes
+func scoreRanking(retrieved, expected []string, k int) (float64, float64, float64) {
+	if len(expected) == 0 {
+		return 0, 0, 0
+	}
+	relevant := make(map[string]bool, len(expected))
+	for _, e := range expected {
+		relevant[filepath.ToSlash(e)] = true
+	}
+
+	var hits int
+	var rr, dcg float64
+	for i, note := range retrieved {
+		if !relevant[note] {
+			continue
+		}
+		hits++
+		if rr == 0 {
+			rr = 1 / float64(i+1)
+		}
+		dcg += 1 / math.Log2(float64(i+2))
+	}
+
+	var idcg float64
+	for i := 0; i < len(expected) && i < k; i++ {
+		idcg += 1 / math.Log2(float64(i+2))
+	}
+	ndcg := 0.0
+	if idcg > 0 {
+		ndcg = dcg / idcg
+	}
+	return float64(hits) / float64(len(expected)), rr, ndcg
+}
+
+func (a *App) judgeFaithfulness(answer string, sources []FileContext) (float64, error) {
+	response, err := a.LLM.GenerateResponse(fmt.Sprintf(judgePrompt, joinContexts(formatContexts(sources)), answer))
+	if err != nil {
+		return 0, err
+	}
+	score, err := strconv.ParseFloat(strings.TrimSpace(response), 64)
+	if err != nil {
+		return 0, fmt.Errorf("judge returned %q, expected a number", strings.TrimSpace(response))
+	}
+	return math.Max(0, math.Min(1, score)), nil
+}
+
+// LoadEvalReport reads a JSON report written by a previous run
+func LoadEvalReport(path string) (*EvalReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var report EvalReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse report %s: %w", path, err)
+	}
+	return &report, nil
+}
+
+// WriteJSON writes the report as indented JSON with stable key order, so two
+// reports can be compared with a plain diff
+func (r *EvalReport) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// WriteText writes a human readable report, one line per question
+func (r *EvalReport) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "config: %s\n", formatEvalConfig(r.Config))
+	fmt.Fprintf(w, "questions: %d\n", r.Questions)
+	fmt.Fprintf(w, "recall@%d: %.3f\n", r.K, r.Recall)
+	fmt.Fprintf(w, "mrr: %.3f\n", r.MRR)
+	fmt.Fprintf(w, "ndcg@%d: %.3f\n", r.K, r.NDCG)
+	if r.Faithfulness != nil {
+		fmt.Fprintf(w, "faithfulness: %.3f\n", *r.Faithfulness)
+	}
+	fmt.Fprintln(w)
+	for _, result := range r.Results {
+		fmt.Fprintf(w, "%s\trecall=%.3f rr=%.3f ndcg=%.3f", result.ID, result.Recall, result.ReciprocalRank, result.NDCG)
+		if result.Faithfulness != nil {
+			fmt.Fprintf(w, " faithfulness=%.3f", *result.Faithfulness)
+		}
+		fmt.Fprintf(w, "\t%s\n", strings.Join(result.Retrieved, ", "))
+		if result.Error != "" {
+			fmt.Fprintf(w, "\terror: %s\n", result.Error)
+		}
+	}
+}
+
+// WriteComparison prints the metrics of a baseline report and r side by side,
+// followed by every question whose scores changed
+func (r *EvalReport) WriteComparison(w io.Writer, baseline *EvalReport) {
+	fmt.Fprintf(w, "baseline: %s\n", formatEvalConfig(baseline.Config))
+	fmt.Fprintf(w, "current:  %s\n\n", formatEvalConfig(r.Config))
+	fmt.Fprintf(w, "%-14s %10s %10s %10s\n", "metric", "baseline", "current", "delta")
+	row := func(name string, before, after float64) {
+		fmt.Fprintf(w, "%-14s %10.3f %10.3f %+10.3f\n", name, before, after, after-before)
+	}
+	row(fmt.Sprintf("recall@%d", r.K), baseline.Recall, r.Recall)
+	row("mrr", baseline.MRR, r.MRR)
+	row(fmt.Sprintf("ndcg@%d", r.K), baseline.NDCG, r.NDCG)
+	if r.Faithfulness != nil && baseline.Faithfulness != nil {
+		row("faithfulness", *baseline.Faithfulness, *r.Faithfulness)
+	}
+
+	before := make(map[string]EvalResult, len(baseline.Results))
+	for _, result := range baseline.Results {
+		before[result.ID] = result
+	}
+	var changed []string
+	for _, result := range r.Results {
+		old, ok := before[result.ID]
+		if !ok {
+			changed = append(changed, fmt.Sprintf("%s\tnew question", result.ID))
+			continue
+		}
+		if old.Recall != result.Recall || old.ReciprocalRank != result.ReciprocalRank || old.NDCG != result.NDCG {
+			changed = append(changed, fmt.Sprintf("%s\trecall %.3f -> %.3f, rr %.3f -> %.3f, ndcg %.3f -> %.3f",
+				result.ID, old.Recall, result.Recall, old.ReciprocalRank, result.ReciprocalRank, old.NDCG, result.NDCG))
+		}
+	}
+	if len(changed) > 0 {
+		fmt.Fprintln(w, "\nchanged questions:")
+		for _, line := range changed {
+			fmt.Fprintln(w, line)
+		}
+	}
+}
+
+func formatEvalConfig(config map[string]string) string {
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, key+"="+config[key])
+	}
+	return strings.Join(parts, " ")
+}
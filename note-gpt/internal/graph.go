@@ -0,0 +1,194 @@
+package internal
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"notescore"
+	"sort"
+
+	"github.com/pinecone-io/go-pinecone/v4/pinecone"
+)
+
+// graphChunksPerNote is how many chunks are fetched per linked note wanted,
+// so that a few long notes don't crowd out the others
+const graphChunksPerNote = 3
+
+// GraphOptions controls how retrieval follows links from the top matches
+type GraphOptions struct {
+	Depth  int     // Link hops to follow, 0 to not follow links
+	Notes  int     // Most linked notes added
+	Decay  float64 // Score multiplier per hop
+	Budget int     // Estimated tokens of all passages linked notes must fit in
+}
+
+// graphNote is a note the link graph is walked from
+type graphNote struct {
+	vaultID string
+	path    string
+	links   []string // Notes it links to, from its metadata
+}
+
+// graphMatch is the best chunk of a note reached by following links
+type graphMatch struct {
+	match *pinecone.ScoredVector // Scored by similarity, decayed per hop
+	depth int
+	via   string // How the note was reached, e.g. "linked from a.md"
+}
+
+// matchNote returns the note a match belongs to. Vectors from before
+// vault-relative paths can't be placed in the link graph.
+func matchNote(match *pinecone.ScoredVector) (graphNote, bool) {
+	if match.Vector == nil || match.Vector.Metadata == nil {
+		return graphNote{}, false
+	}
+	metadata := notescore.ParseChunkMetadata(match.Vector.Metadata.AsMap())
+	if metadata.Path == "" {
+		return graphNote{}, false
+	}
+	return graphNote{vaultID: metadata.VaultID, path: metadata.Path, links: metadata.Links}, true
+}
+
+// expandGraph follows links from the notes of matches, both ways, up to
+// Depth hops and returns the best chunk of each note it reaches, best first.
+// A linked note's score is its own similarity to the query, given by its
+// embedding queryValues, times Decay per hop, so a close note only a hop away
+// can outrank a direct match.
+func (a *App) expandGraph(ctx context.Context, queryValues []float32, matches []*pinecone.ScoredVector) ([]graphMatch, error) {
+	opts := a.options.Graph
+	seen := make(map[string]map[string]bool) // Paths by vault ID
+	var frontier []graphNote
+	for _, match := range matches {
+		note, ok := matchNote(match)
+		if !ok || seen[note.vaultID][note.path] {
+			continue
+		}
+		addToSet(seen, note.vaultID, note.path)
+		frontier = append(frontier, note)
+	}
+
+	var found []graphMatch
+	for depth := 1; depth <= opts.Depth && len(frontier) > 0; depth++ {
+		decay := float32(math.Pow(opts.Decay, float64(depth)))
+		var next []graphNote
+		for _, notes := range byVault(frontier) {
+			vaultID := notes[0].vaultID
+			filter := notescore.NoteFilter{VaultID: vaultID, Exclude: setKeys(seen[vaultID])}
+			linkedFrom := make(map[string]string) // Linked note to a note linking to it
+			for _, note := range notes {
+				filter.LinkedTo = append(filter.LinkedTo, note.path)
+				for _, link := range note.links {
+					if _, ok := linkedFrom[link]; !ok && !seen[vaultID][link] {
+						linkedFrom[link] = note.path
+						filter.Paths = append(filter.Paths, link)
+					}
+				}
+			}
+
+			results, err := a.Vector.QueryValuesNotes(ctx, queryValues, opts.Notes*graphChunksPerNote, filter)
+			if err != nil {
+				return nil, err
+			}
+			// Results come best first, so the first chunk of a note is its best
+			for _, result := range results {
+				note, ok := matchNote(result)
+				if !ok || seen[vaultID][note.path] {
+					continue
+				}
+				addToSet(seen, vaultID, note.path)
+				via := "linked from " + linkedFrom[note.path]
+				if _, ok := linkedFrom[note.path]; !ok {
+					via = "links to " + linkingTo(note, notes)
+				}
+				scored := *result
+				scored.Score = result.Score * decay
+				found = append(found, graphMatch{match: &scored, depth: depth, via: via})
+				next = append(next, note)
+			}
+		}
+		frontier = next
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].match.Score > found[j].match.Score
+	})
+	slog.Debug("Followed links", "notes", len(found), "depth", opts.Depth)
+	return found, nil
+}
+
+// addLinked adds the chunks of linked notes to sources, best first, while
+// there are fewer than Notes of them and all passages fit in Budget
+func (a *App) addLinked(sources []FileContext, linked []graphMatch) []FileContext {
+	used := 0
+	for _, source := range sources {
+		used += notescore.EstimateTokens(source.Content)
+	}
+	matches := make([]*pinecone.ScoredVector, len(linked))
+	byID := make(map[string]graphMatch)
+	for i, link := range linked {
+		matches[i] = link.match
+		byID[link.match.Vector.Id] = link
+	}
+
+	added := 0
+	for _, source := range a.readFilesConcurrently(matches) {
+		if added == a.options.Graph.Notes {
+			break
+		}
+		tokens := notescore.EstimateTokens(source.Content)
+		if used+tokens > a.options.Graph.Budget {
+			slog.Debug("Linked note does not fit the context budget", notescore.KeyPath, source.Path, "tokens", tokens, "used", used)
+			continue
+		}
+		link := byID[source.VectorID]
+		source.Via, source.Depth = link.via, link.depth
+		sources = append(sources, source)
+		used += tokens
+		added++
+	}
+	sortSources(sources)
+	return sources
+}
+
+// byVault groups notes by vault, in order of first appearance
+func byVault(notes []graphNote) [][]graphNote {
+	index := make(map[string]int)
+	var groups [][]graphNote
+	for _, note := range notes {
+		i, ok := index[note.vaultID]
+		if !ok {
+			i = len(groups)
+			index[note.vaultID] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], note)
+	}
+	return groups
+}
+
+// linkingTo returns the first of notes that note links to
+func linkingTo(note graphNote, notes []graphNote) string {
+	for _, target := range notes {
+		for _, link := range note.links {
+			if link == target.path {
+				return target.path
+			}
+		}
+	}
+	return notes[0].path
+}
+
+func addToSet(sets map[string]map[string]bool, key, value string) {
+	if sets[key] == nil {
+		sets[key] = make(map[string]bool)
+	}
+	sets[key][value] = true
+}
+
+func setKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"math"
+	"math/rand"
+	"notescore"
+)
+
+// kmeansIterations caps the rounds of reassignment, which usually settle
+// well before it
+const kmeansIterations = 100
+
+// kmeans clusters unit-length points into k groups by cosine similarity,
+// spherical k-means with k-means++ seeding. It returns the cluster of each
+// point and the unit-length centroid of each cluster. rng seeds the
+// centroids, so a fixed seed gives the same clusters for the same points.
+func kmeans(points [][]float32, k int, rng *rand.Rand) ([]int, [][]float32) {
+	k = min(k, len(points))
+	if k < 1 {
+		return nil, nil
+	}
+	centroids := seedCentroids(points, k, rng)
+	assignment := make([]int, len(points))
+	for i := range assignment {
+		assignment[i] = -1
+	}
+
+	for iteration := 0; iteration < kmeansIterations; iteration++ {
+		changed := false
+		for i, point := range points {
+			best, _ := nearestCentroid(point, centroids)
+			if best != assignment[i] {
+				assignment[i] = best
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+		centroids = meanCentroids(points, assignment, k)
+		fillEmptyClusters(points, assignment, centroids)
+	}
+	return assignment, centroids
+}
+
+// seedCentroids picks k points as the initial centroids, each one with a
+// probability proportional to its squared distance from the nearest one
+// already picked, which spreads them over the space
+func seedCentroids(points [][]float32, k int, rng *rand.Rand) [][]float32 {
+	centroids := [][]float32{points[rng.Intn(len(points))]}
+	distances := make([]float64, len(points))
+	for len(centroids) < k {
+		var total float64
+		for i, point := range points {
+			_, similarity := nearestCentroid(point, centroids)
+			d := math.Max(0, 1-float64(similarity))
+			distances[i] = d * d
+			total += distances[i]
+		}
+		next := 0
+		if total == 0 {
+			// Every point sits on a centroid, any is as good as another
+			next = rng.Intn(len(points))
+		} else {
+			target := rng.Float64() * total
+			for i, d := range distances {
+				target -= d
+				if target <= 0 {
+					next = i
+					break
+				}
+			}
+		}
+		centroids = append(centroids, points[next])
+	}
+	return centroids
+}
+
+// meanCentroids returns the normalized mean of the points of each cluster.
+// Empty clusters get a nil centroid.
+func meanCentroids(points [][]float32, assignment []int, k int) [][]float32 {
+	centroids := make([][]float32, k)
+	for i, point := range points {
+		cluster := assignment[i]
+		if centroids[cluster] == nil {
+			centroids[cluster] = make([]float32, len(point))
+		}
+		for j, value := range point {
+			if j < len(centroids[cluster]) {
+				centroids[cluster][j] += value
+			}
+		}
+	}
+	for _, centroid := range centroids {
+		if centroid != nil {
+			notescore.Normalize(centroid)
+		}
+	}
+	return centroids
+}
+
+// fillEmptyClusters moves the point furthest from its centroid into each
+// cluster left empty, so that k clusters come out whenever there are k points
+func fillEmptyClusters(points [][]float32, assignment []int, centroids [][]float32) {
+	for cluster, centroid := range centroids {
+		if centroid != nil {
+			continue
+		}
+		sizes := make(map[int]int)
+		for _, c := range assignment {
+			sizes[c]++
+		}
+		worst, worstSimilarity := -1, float32(2)
+		for i, point := range points {
+			if sizes[assignment[i]] < 2 {
+				continue
+			}
+			if similarity := notescore.Dot(point, centroids[assignment[i]]); similarity < worstSimilarity {
+				worst, worstSimilarity = i, similarity
+			}
+		}
+		if worst < 0 {
+			continue
+		}
+		assignment[worst] = cluster
+		centroids[cluster] = append([]float32(nil), points[worst]...)
+	}
+}
+
+// nearestCentroid returns the index of the centroid most similar to point
+// and the similarity. Nil centroids are skipped.
+func nearestCentroid(point []float32, centroids [][]float32) (int, float32) {
+	best, bestSimilarity := 0, float32(-2)
+	for c, centroid := range centroids {
+		if centroid == nil {
+			continue
+		}
+		if similarity := notescore.Dot(point, centroid); similarity > bestSimilarity {
+			best, bestSimilarity = c, similarity
+		}
+	}
+	return best, bestSimilarity
+}
@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"notescore"
+	"sort"
+
+	"github.com/pinecone-io/go-pinecone/v4/pinecone"
+)
+
+// maxRelatedChunks caps the chunks of a long note searched with, spread
+// evenly over the note
+const maxRelatedChunks = 16
+
+// RelatedNote is a note similar to another one
+type RelatedNote struct {
+	NoteRef
+	Score   float32 // Best similarity between one of its chunks and one of the other note's
+	Matches int     // How many of its chunks were among the nearest neighbours
+}
+
+// Related finds the k notes most like the note at path, see locateNote. It
+// searches with the stored vector of each chunk of the note, so nothing is
+// embedded, and ranks the other notes by their best matching chunk, then by
+// how many of their chunks matched.
+func (a *App) Related(ctx context.Context, path string, k int) (NoteRef, []RelatedNote, error) {
+	source, note, ok := a.locateNote(path)
+	if !ok {
+		return NoteRef{}, nil, fmt.Errorf("no note %s in the configured sources", path)
+	}
+	vaultID, err := notescore.ReadVaultID(source.Path)
+	if err != nil {
+		return note, nil, fmt.Errorf("source %s has not been synced: %w", source.Name, err)
+	}
+	vectors, err := a.Vector.FetchFile(ctx, source.Namespace, notescore.FileId(vaultID, note.Path))
+	if err != nil {
+		return note, nil, fmt.Errorf("failed to fetch the vectors of %s: %w", note.Path, err)
+	}
+	if len(vectors) == 0 {
+		return note, nil, fmt.Errorf("%s has no vectors yet, is vector-sync running?", note.Path)
+	}
+	if len(vectors) > maxRelatedChunks {
+		sampled := make([]*pinecone.Vector, maxRelatedChunks)
+		for i := range sampled {
+			sampled[i] = vectors[i*len(vectors)/maxRelatedChunks]
+		}
+		vectors = sampled
+	}
+
+	// The note's own chunks are among the neighbours of each of its chunks
+	topK := k*graphChunksPerNote + len(vectors)
+	byNote := make(map[string]*RelatedNote)
+	for _, vector := range vectors {
+		if vector.Values == nil {
+			continue
+		}
+		matches, err := a.Vector.QueryValues(ctx, *vector.Values, topK)
+		if err != nil {
+			return note, nil, fmt.Errorf("failed to query vector database: %w", err)
+		}
+		for _, match := range matches {
+			if match.Vector == nil || match.Vector.Metadata == nil {
+				continue
+			}
+			matchSource, relPath, ok := a.resolveNote(notescore.ParseChunkMetadata(match.Vector.Metadata.AsMap()))
+			if !ok {
+				continue
+			}
+			ref := noteRef(matchSource, relPath)
+			if ref.FilePath == note.FilePath {
+				continue
+			}
+			related, ok := byNote[ref.FilePath]
+			if !ok {
+				related = &RelatedNote{NoteRef: ref}
+				byNote[ref.FilePath] = related
+			}
+			related.Score = max(related.Score, match.Score)
+			related.Matches++
+		}
+	}
+
+	ranked := make([]RelatedNote, 0, len(byNote))
+	for _, related := range byNote {
+		ranked = append(ranked, *related)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		if ranked[i].Matches != ranked[j].Matches {
+			return ranked[i].Matches > ranked[j].Matches
+		}
+		return ranked[i].FilePath < ranked[j].FilePath
+	})
+	if len(ranked) > k {
+		ranked = ranked[:k]
+	}
+	return note, ranked, nil
+}
@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"fmt"
+	"log/slog"
+	"notescore"
+	"strings"
+)
+
+// RetrievalOptions controls how a user query is turned into vector searches
+type RetrievalOptions struct {
+	Rewrite  bool // Condense the query and recent turns into a standalone query
+	HyDE     bool // Add a hypothetical answer passage as an extra search query
+	FanOut   int  // Total number of query phrasings to search with
+	Mentions int  // Most notes a query's alias and #tag mentions add, 0 to ignore them
+	Graph    GraphOptions
+}
+
+const rewritePrompt = `You rewrite questions for a semantic search engine over the user's personal notes.
+Given the conversation so far and a new question, write the new question as a single standalone search query
+that can be understood without the conversation. Resolve pronouns and references like "it", "that" or "the recipe".
+%sReply with one query per line and nothing else. Do not number the lines or add quotes.`
+
+const hydePrompt = `Write a short passage (at most 5 sentences) that could appear in a personal note and answers the question below.
+It does not need to be correct; it is only used to find similar notes. Reply with the passage only.
+
+Question: %s`
+
+// rewriteQuery turns the user query into the list of search queries used for
+// retrieval. The first entry is always the standalone query. On any LLM error
+// it falls back to the original query so retrieval still works.
+func (a *App) rewriteQuery(query string) []string {
+	queries := []string{query}
+	if a.LLM == nil {
+		return queries
+	}
+
+	history := a.recentTurns(rewriteHistoryTurns)
+	if a.options.Rewrite && (len(history) > 0 || a.options.FanOut > 1) {
+		rewritten, err := a.condenseQuery(query, history)
+		if err != nil {
+			slog.Warn("Query rewrite failed, using the original query", notescore.KeyErr, err)
+		} else if len(rewritten) > 0 {
+			queries = rewritten
+		}
+	}
+
+	if a.options.HyDE {
+		passage, err := a.LLM.GenerateResponse(fmt.Sprintf(hydePrompt, queries[0]))
+		if err != nil {
+			slog.Warn("Hypothetical answer generation failed", notescore.KeyErr, err)
+		} else if passage = strings.TrimSpace(passage); passage != "" {
+			queries = append(queries, passage)
+		}
+	}
+
+	return queries
+}
+
+func (a *App) condenseQuery(query string, history []ConversationTurn) ([]string, error) {
+	variants := ""
+	if a.options.FanOut > 1 {
+		variants = fmt.Sprintf("After the standalone query, add %d alternative phrasings of it using different keywords, one per line.\n", a.options.FanOut-1)
+	}
+
+	var prompt strings.Builder
+	fmt.Fprintf(&prompt, rewritePrompt, variants)
+	prompt.WriteString("\n\n<CONVERSATION_HISTORY>\n")
+	for _, turn := range history {
+		fmt.Fprintf(&prompt, "Question: %s\nAnswer: %s\n\n", turn.Query, turn.Response)
+	}
+	prompt.WriteString("</CONVERSATION_HISTORY>\n\n")
+	fmt.Fprintf(&prompt, "New question: %s\n", query)
+
+	response, err := a.LLM.GenerateResponse(prompt.String())
+	if err != nil {
+		return nil, err
+	}
+
+	var queries []string
+	for _, line := range strings.Split(response, "\n") {
+		line = cleanQueryLine(line)
+		if line == "" {
+			continue
+		}
+		queries = append(queries, line)
+		if len(queries) == a.options.FanOut {
+			break
+		}
+	}
+	return queries, nil
+}
+
+// cleanQueryLine strips list markers and quotes the LLM sometimes adds despite
+// being told not to
+func cleanQueryLine(line string) string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimLeft(line, "-*• ")
+	if i := strings.Index(line, ". "); i > 0 && i <= 3 && strings.Trim(line[:i], "0123456789") == "" {
+		line = line[i+2:]
+	}
+	return strings.Trim(strings.TrimSpace(line), `"'`)
+}
@@ -0,0 +1,162 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"notescore"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxTitleRunes caps the file name of a saved answer named after its
+// question
+const maxTitleRunes = 80
+
+// SaveAnswer writes the last answer, with its question and the notes it
+// cites, as a new note in the inbox folder and returns where. The note goes
+// into the vault of the first cited note, or of the first searched source
+// when nothing was cited, so that vector-sync indexes it with that vault.
+// The note is named title, or after the question when title is empty, and
+// an existing file is never overwritten.
+func (a *App) SaveAnswer(title string) (string, error) {
+	turn, ok := a.LastTurn()
+	if !ok {
+		return "", fmt.Errorf("no answer to save yet")
+	}
+	var cited []FileContext
+	var numbers []int
+	for _, citation := range turn.Citations {
+		if citation.Number >= 1 && citation.Number <= len(turn.Sources) {
+			cited = append(cited, turn.Sources[citation.Number-1])
+			numbers = append(numbers, citation.Number)
+		}
+	}
+
+	var source SourceConfig
+	found := false
+	if len(cited) > 0 {
+		source, found = a.config.SourceNamed(cited[0].Source)
+	}
+	if !found {
+		searched := a.searchedSources()
+		if len(searched) == 0 {
+			return "", fmt.Errorf("no source to save into")
+		}
+		source = searched[0]
+	}
+
+	if title == "" {
+		title = turn.Query
+	}
+	name := strings.TrimSuffix(noteFileName(title), ".md")
+	if name == "" {
+		name = "Answer " + time.Now().Format("2006-01-02 150405")
+	}
+	relPath := path.Join(filepath.ToSlash(a.config.Retrieval.Inbox), name+".md")
+	filePath, err := vaultFile(source, relPath)
+	if err != nil {
+		return "", err
+	}
+
+	content := a.answerNote(source, name, turn, cited, numbers)
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return "", err
+	}
+	// O_EXCL makes the existence check and the create one step
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return "", fmt.Errorf("%s already exists in source %s, save it under another title", relPath, source.Name)
+	}
+	if err != nil {
+		return "", err
+	}
+	if _, err := file.WriteString(content); err != nil {
+		file.Close()
+		os.Remove(filePath)
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(filePath)
+		return "", err
+	}
+	return filePath, nil
+}
+
+// answerNote renders a saved answer: front matter with when it was created,
+// the notes it cites and the model that wrote it, then the question, the
+// answer and its sources. Cited notes in the same vault are wikilinked,
+// notes in other vaults are linked by URI.
+func (a *App) answerNote(source SourceConfig, title string, turn ConversationTurn, cited []FileContext, numbers []int) string {
+	links := make([]string, len(cited))
+	for i, note := range cited {
+		if note.Source == source.Name && note.Path != "" {
+			links[i] = wikilink(note.Path)
+		} else {
+			links[i] = fmt.Sprintf("[%s](%s)", notescore.NoteTitle(note.FilePath), a.citationURI(note))
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("---\n")
+	fmt.Fprintf(&b, "created: %s\n", time.Now().Format("2006-01-02T15:04:05"))
+	b.WriteString("sources:")
+	seen := make(map[string]bool)
+	for i, note := range cited {
+		value := links[i]
+		if note.Source != source.Name || note.Path == "" {
+			value = a.citationURI(note)
+		}
+		if seen[value] {
+			continue
+		}
+		seen[value] = true
+		fmt.Fprintf(&b, "\n  - %s", yamlString(value))
+	}
+	if len(seen) == 0 {
+		b.WriteString(" []")
+	}
+	fmt.Fprintf(&b, "\nmodel: %s\n", yamlString(a.config.LLM.Model))
+	b.WriteString("---\n\n")
+
+	fmt.Fprintf(&b, "# %s\n\n", title)
+	for _, line := range strings.Split(strings.TrimSpace(turn.Query), "\n") {
+		b.WriteString(strings.TrimRight("> "+line, " ") + "\n")
+	}
+	b.WriteString("\n" + strings.TrimSpace(turn.Response) + "\n")
+	if len(cited) > 0 {
+		b.WriteString("\n## Sources\n\n")
+		for i, note := range cited {
+			fmt.Fprintf(&b, "- [%d] %s, lines %d-%d\n", numbers[i], links[i], note.StartLine, note.EndLine)
+		}
+	}
+	return b.String()
+}
+
+// noteFileName turns title into a file name Obsidian accepts and links to:
+// characters it forbids or reads as link syntax are dropped, whitespace is
+// collapsed and long titles are cut at a word
+func noteFileName(title string) string {
+	title = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`\/:*?"<>|#^[]`, r) || r < ' ' {
+			return ' '
+		}
+		return r
+	}, title)
+	title = strings.Join(strings.Fields(title), " ")
+	if runes := []rune(title); len(runes) > maxTitleRunes {
+		title = string(runes[:maxTitleRunes])
+		if cut := strings.LastIndex(title, " "); cut > maxTitleRunes/2 {
+			title = title[:cut]
+		}
+	}
+	return strings.Trim(title, ". ")
+}
+
+// yamlString quotes s as a YAML string. JSON strings are valid YAML.
+func yamlString(s string) string {
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
@@ -0,0 +1,247 @@
+package internal
+
+import (
+	"context"
+	"log/slog"
+	"notescore"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pinecone-io/go-pinecone/v4/pinecone"
+)
+
+// tagTable is the tag table of one source as last read
+type tagTable struct {
+	table   *notescore.TagTable
+	vaultID string
+	modTime time.Time
+}
+
+// mention is a note a query refers to by one of its aliases or tags
+type mention struct {
+	source  SourceConfig
+	vaultID string
+	path    string
+	alias   string // The alias the query used, empty for tags
+	term    string // How the query refers to the note, e.g. "alias OB"
+}
+
+// TagCount is a tag and the number of notes that have it
+type TagCount struct {
+	Tag   string
+	Notes int
+}
+
+// sourceTable returns the tag table vector-sync wrote into source, reading it
+// again whenever it was rewritten, and the source's vault ID. Sources
+// without a table have an empty one.
+func (a *App) sourceTable(source SourceConfig) (*notescore.TagTable, string) {
+	empty := &notescore.TagTable{Notes: make(map[string]notescore.NoteTerms)}
+	info, err := os.Stat(notescore.TagTablePath(source.Path))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("Failed to read the tag table", notescore.KeySource, source.Name, notescore.KeyErr, err)
+		}
+		return empty, ""
+	}
+
+	a.tablesMu.Lock()
+	defer a.tablesMu.Unlock()
+	if cached, ok := a.tables[source.Name]; ok && cached.modTime.Equal(info.ModTime()) {
+		return cached.table, cached.vaultID
+	}
+	table, err := notescore.ReadTagTable(source.Path)
+	if err != nil {
+		slog.Warn("Failed to read the tag table", notescore.KeySource, source.Name, notescore.KeyErr, err)
+		return empty, ""
+	}
+	vaultID, _ := notescore.ReadVaultID(source.Path)
+	a.tables[source.Name] = &tagTable{table: table, vaultID: vaultID, modTime: info.ModTime()}
+	return table, vaultID
+}
+
+// searchedSources returns the sources queries currently run against
+func (a *App) searchedSources() []SourceConfig {
+	if len(a.config.Retrieval.Sources) == 0 {
+		return a.config.AllSources()
+	}
+	var sources []SourceConfig
+	for _, name := range a.config.Retrieval.Sources {
+		if source, ok := a.config.SourceNamed(name); ok {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+// Tags returns the tags of the notes in the searched sources, most used
+// first
+func (a *App) Tags() []TagCount {
+	counts := make(map[string]int)
+	for _, source := range a.searchedSources() {
+		table, _ := a.sourceTable(source)
+		for tag, n := range table.Tags() {
+			counts[tag] += n
+		}
+	}
+	tags := make([]TagCount, 0, len(counts))
+	for tag, n := range counts {
+		tags = append(tags, TagCount{Tag: tag, Notes: n})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Notes != tags[j].Notes {
+			return tags[i].Notes > tags[j].Notes
+		}
+		return tags[i].Tag < tags[j].Tag
+	})
+	return tags
+}
+
+// Tagged returns the notes in the searched sources with tag or a tag nested
+// under it
+func (a *App) Tagged(tag string) []NoteRef {
+	var notes []NoteRef
+	for _, source := range a.searchedSources() {
+		table, _ := a.sourceTable(source)
+		for _, path := range table.Tagged(tag) {
+			notes = append(notes, noteRef(source, filepath.FromSlash(path)))
+		}
+	}
+	return notes
+}
+
+// findMentions returns the notes text names by one of their aliases, or by
+// a #tag they have
+func (a *App) findMentions(text string) []mention {
+	lower := strings.ToLower(text)
+	tags := notescore.ParseTags([]byte(text))
+	var mentions []mention
+	for _, source := range a.searchedSources() {
+		table, vaultID := a.sourceTable(source)
+		paths := make([]string, 0, len(table.Notes))
+		for path := range table.Notes {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		found := make(map[string]bool)
+		for _, path := range paths {
+			for _, alias := range table.Notes[path].Aliases {
+				if containsPhrase(lower, strings.ToLower(alias)) {
+					found[path] = true
+					mentions = append(mentions, mention{source: source, vaultID: vaultID, path: path, alias: alias, term: "alias " + alias})
+					break
+				}
+			}
+		}
+		for _, tag := range tags {
+			for _, path := range table.Tagged(tag) {
+				if !found[path] {
+					found[path] = true
+					mentions = append(mentions, mention{source: source, vaultID: vaultID, path: path, term: "tag #" + tag})
+				}
+			}
+		}
+	}
+	return mentions
+}
+
+// expandAliases adds the titles of the notes query names by alias, so the
+// search also finds what the notes are about rather than only the alias
+func expandAliases(query string, mentions []mention) string {
+	lower := strings.ToLower(query)
+	var titles []string
+	for _, m := range mentions {
+		title := notescore.NoteTitle(m.path)
+		if m.alias != "" && containsPhrase(lower, strings.ToLower(m.alias)) && !strings.Contains(lower, strings.ToLower(title)) {
+			titles = append(titles, title)
+		}
+	}
+	if len(titles) == 0 {
+		return query
+	}
+	return query + " (" + strings.Join(titles, ", ") + ")"
+}
+
+// mentionedMatches returns the best chunk of each mentioned note not among
+// matches yet, best first and up to Mentions of them, with the term each was
+// mentioned by keyed by vector id
+func (a *App) mentionedMatches(ctx context.Context, queryValues []float32, mentions []mention, matches []*pinecone.ScoredVector) ([]*pinecone.ScoredVector, map[string]string, error) {
+	present := make(map[string]map[string]bool) // Paths by vault ID
+	for _, match := range matches {
+		if note, ok := matchNote(match); ok {
+			addToSet(present, note.vaultID, note.path)
+		}
+	}
+	byVault := make(map[string][]mention)
+	var vaults []string
+	for _, m := range mentions {
+		if present[m.vaultID][m.path] {
+			continue
+		}
+		if _, ok := byVault[m.vaultID]; !ok {
+			vaults = append(vaults, m.vaultID)
+		}
+		byVault[m.vaultID] = append(byVault[m.vaultID], m)
+	}
+
+	var found []*pinecone.ScoredVector
+	terms := make(map[string]string)
+	for _, vaultID := range vaults {
+		filter := notescore.NoteFilter{VaultID: vaultID}
+		termOf := make(map[string]string)
+		for _, m := range byVault[vaultID] {
+			filter.Paths = append(filter.Paths, m.path)
+			termOf[m.path] = m.term
+		}
+		results, err := a.Vector.QueryValuesNotes(ctx, queryValues, a.options.Mentions*graphChunksPerNote, filter)
+		if err != nil {
+			return nil, nil, err
+		}
+		seen := make(map[string]bool)
+		for _, result := range results {
+			note, ok := matchNote(result)
+			if !ok || seen[note.path] {
+				continue
+			}
+			seen[note.path] = true
+			found = append(found, result)
+			terms[result.Vector.Id] = termOf[note.path]
+		}
+	}
+	sortByScore(found)
+	if len(found) > a.options.Mentions {
+		found = found[:a.options.Mentions]
+	}
+	return found, terms, nil
+}
+
+// containsPhrase reports whether text contains phrase as whole words
+func containsPhrase(text, phrase string) bool {
+	if phrase == "" {
+		return false
+	}
+	for offset := 0; offset < len(text); {
+		i := strings.Index(text[offset:], phrase)
+		if i < 0 {
+			return false
+		}
+		start, end := offset+i, offset+i+len(phrase)
+		before, _ := utf8.DecodeLastRuneInString(text[:start])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		if !isWordRune(before) && !isWordRune(after) {
+			return true
+		}
+		offset = start + 1
+	}
+	return false
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
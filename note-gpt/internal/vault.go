@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"fmt"
+	"log/slog"
+	"notescore"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// applyActiveIndexes points each source at the namespace vector-sync is
+// syncing it into, so queries follow a reindex without editing the config.
+// The index and embedding model are shared by all sources and are only
+// taken over when every recorded source agrees on them.
+func applyActiveIndexes(c *Config) {
+	sources := c.AllSources()
+	var hosts, indexes, embedders []string
+	for i, source := range sources {
+		if source.Path == "" {
+			continue
+		}
+		active, err := notescore.ReadActiveIndex(source.Path)
+		if err != nil {
+			slog.Warn("Ignoring active index", notescore.KeyErr, err)
+			continue
+		}
+		if active == nil {
+			continue
+		}
+		sources[i].Namespace = active.Namespace
+		hosts = appendUnique(hosts, active.Host)
+		indexes = appendUnique(indexes, active.Index)
+		embedders = appendUnique(embedders, active.Embedder)
+	}
+	if len(embedders) == 0 {
+		return
+	}
+	c.Sources = sources
+
+	if len(hosts) == 1 && len(indexes) == 1 {
+		c.VectorStore.Host = hosts[0]
+		c.VectorStore.Index = indexes[0]
+	} else if len(hosts) > 1 || len(indexes) > 1 {
+		slog.Warn("Sources are indexed in different indexes", "querying", c.VectorStore.Index)
+	}
+	if len(embedders) == 1 {
+		c.Embedder.Model = embedders[0]
+	} else if len(embedders) > 1 {
+		slog.Warn("Sources are indexed with different models", "models", strings.Join(embedders, ", "), "querying", c.Embedder.Model)
+	}
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+// resolveNote maps the location stored in a vector's metadata to a source and
+// a path on this machine. Vectors written by vector-sync carry the vault ID
+// and a vault-relative path, so they resolve against whatever directory the
+// vault is configured at here. If the vault ID is unknown the source name is
+// used instead. Vectors from before vault IDs only carry an absolute path.
+func (a *App) resolveNote(metadata notescore.ChunkMetadata) (SourceConfig, string, bool) {
+	if metadata.Path != "" {
+		source, found := a.vaults[metadata.VaultID]
+		if !found {
+			source, found = a.config.SourceNamed(metadata.Source)
+		}
+		if !found {
+			return SourceConfig{}, "", false
+		}
+		return source, filepath.FromSlash(metadata.Path), true
+	}
+
+	abs := metadata.Filepath
+	if abs == "" {
+		return SourceConfig{}, "", false
+	}
+	source, found := a.config.SourceFor(abs)
+	if !found {
+		return SourceConfig{Path: filepath.Dir(abs)}, filepath.Base(abs), true
+	}
+	rel, err := filepath.Rel(source.Path, abs)
+	if err != nil {
+		return SourceConfig{}, "", false
+	}
+	return source, rel, true
+}
+
+// NoteRef is a note of one of the configured sources
+type NoteRef struct {
+	Source   string
+	Path     string // Relative to the vault root
+	FilePath string // Absolute path on this machine
+}
+
+// locateNote finds the note at path, which is either absolute or relative
+// to the root of a source, trying the searched sources first. The .md
+// extension may be left out.
+func (a *App) locateNote(path string) (SourceConfig, NoteRef, bool) {
+	candidates := []string{path}
+	if !strings.HasSuffix(path, ".md") {
+		candidates = append(candidates, path+".md")
+	}
+	for _, candidate := range candidates {
+		if filepath.IsAbs(candidate) {
+			source, found := a.config.SourceFor(candidate)
+			if !found {
+				continue
+			}
+			if rel, err := filepath.Rel(source.Path, candidate); err == nil && isFile(candidate) {
+				return source, noteRef(source, rel), true
+			}
+			continue
+		}
+		for _, source := range append(a.searchedSources(), a.config.AllSources()...) {
+			if isFile(filepath.Join(source.Path, candidate)) {
+				return source, noteRef(source, candidate), true
+			}
+		}
+	}
+	return SourceConfig{}, NoteRef{}, false
+}
+
+func noteRef(source SourceConfig, relPath string) NoteRef {
+	return NoteRef{
+		Source:   source.Name,
+		Path:     filepath.ToSlash(filepath.Clean(relPath)),
+		FilePath: filepath.Join(source.Path, relPath),
+	}
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// vaultFile returns where the file at relPath in source lives on this
+// machine. It fails for paths leading outside the vault, including through
+// symlinked directories.
+func vaultFile(source SourceConfig, relPath string) (string, error) {
+	relPath = filepath.FromSlash(relPath)
+	if !filepath.IsLocal(relPath) {
+		return "", fmt.Errorf("%s is outside the vault", relPath)
+	}
+	root, err := filepath.EvalSymlinks(source.Path)
+	if err != nil {
+		return "", err
+	}
+	// Resolve the deepest existing directory, anything below it is created
+	dir := filepath.Dir(filepath.Join(source.Path, relPath))
+	for {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			if rel, err := filepath.Rel(root, resolved); err != nil || !filepath.IsLocal(rel) {
+				return "", fmt.Errorf("%s is outside the vault", relPath)
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		dir = filepath.Dir(dir)
+	}
+	return filepath.Join(source.Path, relPath), nil
+}
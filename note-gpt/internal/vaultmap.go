@@ -0,0 +1,330 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"notescore"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pinecone-io/go-pinecone/v4/pinecone"
+)
+
+const (
+	// mapStart and mapEnd enclose the generated part of a map note. Only
+	// that part is replaced when the map is rebuilt.
+	mapStart = "<!-- vector-notes:map start -->"
+	mapEnd   = "<!-- vector-notes:map end -->"
+
+	// mapRepresentatives is how many of the notes closest to the centre of
+	// a topic the LLM names it from
+	mapRepresentatives = 5
+
+	// mapExcerptRunes caps the text of each representative note in the
+	// labelling prompt
+	mapExcerptRunes = 600
+
+	// mapMaxTopics caps the number of topics picked when none is asked for
+	mapMaxTopics = 12
+
+	// mapSeed makes clustering repeatable, so an unchanged vault keeps its
+	// map
+	mapSeed = 1
+)
+
+// MapOptions controls how vault maps are built
+type MapOptions struct {
+	Topics int    // Clusters to group the notes into, 0 to pick from the number of notes
+	Note   string // Vault-relative path of the map note
+}
+
+// VaultMap is the notes of one source grouped into topics
+type VaultMap struct {
+	Source SourceConfig
+	Note   string // Vault-relative path of the map note
+	Notes  int    // Notes that were clustered
+	Topics []Topic
+}
+
+// Topic is a cluster of notes about the same thing
+type Topic struct {
+	Label       string
+	Description string
+	Notes       []NoteRef // Closest to the centre of the cluster first
+}
+
+// VaultMaps clusters the notes of each searched source by their stored
+// vectors and names each cluster. A note's vector is the mean of its chunk
+// vectors, so nothing is embedded. The LLM names the clusters from the
+// notes closest to their centres; without one, or if it fails, a cluster is
+// named after its most shared tag or its most central note.
+func (a *App) VaultMaps(ctx context.Context, options MapOptions) ([]*VaultMap, error) {
+	var maps []*VaultMap
+	for _, source := range a.searchedSources() {
+		m, err := a.vaultMap(ctx, source, options)
+		if err != nil {
+			return nil, fmt.Errorf("source %s: %w", source.Name, err)
+		}
+		maps = append(maps, m)
+	}
+	return maps, nil
+}
+
+func (a *App) vaultMap(ctx context.Context, source SourceConfig, options MapOptions) (*VaultMap, error) {
+	if _, err := notescore.ReadVaultID(source.Path); err != nil {
+		return nil, fmt.Errorf("not synced yet: %w", err)
+	}
+	embeddings, err := a.noteEmbeddings(ctx, source, options.Note)
+	if err != nil {
+		return nil, err
+	}
+	if len(embeddings) == 0 {
+		return nil, fmt.Errorf("no notes have vectors yet, is vector-sync running?")
+	}
+
+	paths := make([]string, 0, len(embeddings))
+	for path := range embeddings {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	points := make([][]float32, len(paths))
+	for i, path := range paths {
+		points[i] = embeddings[path]
+	}
+
+	k := options.Topics
+	if k < 1 {
+		k = int(math.Round(math.Sqrt(float64(len(points)) / 2)))
+		k = max(1, min(k, mapMaxTopics))
+	}
+	assignment, centroids := kmeans(points, k, rand.New(rand.NewSource(mapSeed)))
+
+	members := make([][]int, len(centroids))
+	for i, cluster := range assignment {
+		members[cluster] = append(members[cluster], i)
+	}
+	m := &VaultMap{Source: source, Note: options.Note, Notes: len(paths)}
+	for cluster, indexes := range members {
+		if len(indexes) == 0 {
+			continue
+		}
+		centroid := centroids[cluster]
+		sort.SliceStable(indexes, func(i, j int) bool {
+			return notescore.Dot(points[indexes[i]], centroid) > notescore.Dot(points[indexes[j]], centroid)
+		})
+		var topic Topic
+		for _, i := range indexes {
+			topic.Notes = append(topic.Notes, noteRef(source, filepath.FromSlash(paths[i])))
+		}
+		topic.Label, topic.Description = a.labelTopic(source, topic.Notes)
+		m.Topics = append(m.Topics, topic)
+	}
+	sort.SliceStable(m.Topics, func(i, j int) bool {
+		if len(m.Topics[i].Notes) != len(m.Topics[j].Notes) {
+			return len(m.Topics[i].Notes) > len(m.Topics[j].Notes)
+		}
+		return m.Topics[i].Label < m.Topics[j].Label
+	})
+	return m, nil
+}
+
+// noteEmbeddings returns the normalized mean chunk vector of each note of
+// source, keyed by vault-relative path, leaving out the note at skip
+func (a *App) noteEmbeddings(ctx context.Context, source SourceConfig, skip string) (map[string][]float32, error) {
+	vectors, err := a.Vector.FetchNamespace(ctx, source.Namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch vectors: %w", err)
+	}
+	return notescore.NoteEmbeddings(vectors, func(vector *pinecone.Vector) string {
+		if vector.Metadata == nil {
+			return ""
+		}
+		noteSource, relPath, ok := a.resolveNote(notescore.ParseChunkMetadata(vector.Metadata.AsMap()))
+		if !ok || noteSource.Name != source.Name {
+			return ""
+		}
+		path := filepath.ToSlash(relPath)
+		if path == skip {
+			return ""
+		}
+		return path
+	}), nil
+}
+
+// labelTopic names a cluster of notes and describes it in a sentence
+func (a *App) labelTopic(source SourceConfig, notes []NoteRef) (string, string) {
+	if a.LLM != nil {
+		response, err := a.LLM.GenerateResponse(topicPrompt(notes))
+		if err == nil {
+			if label, description := parseTopicLabel(response); label != "" {
+				return label, description
+			}
+			err = fmt.Errorf("no label in the response")
+		}
+		slog.Warn("Naming a topic failed, naming it after its notes", notescore.KeySource, source.Name, notescore.KeyErr, err)
+	}
+	table, _ := a.sourceTable(source)
+	return fallbackLabel(table, notes), ""
+}
+
+// fallbackLabel names a cluster after the tag most of its notes share, or
+// else after its most central note
+func fallbackLabel(table *notescore.TagTable, notes []NoteRef) string {
+	counts := make(map[string]int)
+	for _, note := range notes {
+		for _, tag := range table.Notes[note.Path].Tags {
+			counts[tag]++
+		}
+	}
+	best := ""
+	for tag, n := range counts {
+		if n > counts[best] || (n == counts[best] && tag < best) {
+			best = tag
+		}
+	}
+	if len(notes) > 1 && counts[best]*2 > len(notes) {
+		return "#" + best
+	}
+	return notescore.NoteTitle(notes[0].Path)
+}
+
+// topicPrompt asks the LLM to name the notes closest to a cluster's centre
+func topicPrompt(notes []NoteRef) string {
+	var b strings.Builder
+	b.WriteString("The following notes from a personal knowledge base were grouped together because their content is similar. ")
+	b.WriteString("Name the topic they share.\n\n")
+	b.WriteString("Reply with exactly two lines and nothing else:\n")
+	b.WriteString("Label: a topic name of at most five words\n")
+	b.WriteString("Description: one sentence on what the notes cover\n")
+	for _, note := range notes[:min(len(notes), mapRepresentatives)] {
+		fmt.Fprintf(&b, "\n## %s\n", notescore.NoteTitle(note.Path))
+		content, err := os.ReadFile(note.FilePath)
+		if err != nil {
+			continue
+		}
+		excerpt := []rune(strings.TrimSpace(string(notescore.StripFrontMatter(content))))
+		if len(excerpt) > mapExcerptRunes {
+			excerpt = append(excerpt[:mapExcerptRunes], '…')
+		}
+		b.WriteString(string(excerpt))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// parseTopicLabel reads the label and description from the LLM's reply,
+// tolerating missing prefixes and Markdown emphasis
+func parseTopicLabel(response string) (string, string) {
+	var lines []string
+	for _, line := range strings.Split(response, "\n") {
+		line = strings.Trim(strings.TrimSpace(line), "*#_\"` ")
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	var label, description string
+	for i, line := range lines {
+		lower := strings.ToLower(line)
+		switch {
+		case strings.HasPrefix(lower, "label:"):
+			label = line[len("label:"):]
+		case strings.HasPrefix(lower, "description:"):
+			description = line[len("description:"):]
+		case i == 0 && label == "":
+			label = line
+		case i == 1 && description == "":
+			description = line
+		}
+	}
+	return cleanMapText(label), cleanMapText(description)
+}
+
+// cleanMapText keeps text from breaking the Markdown it is put in
+func cleanMapText(text string) string {
+	text = strings.Trim(strings.TrimSpace(text), "*_\"` ")
+	return strings.NewReplacer("[[", "", "]]", "", mapStart, "", mapEnd, "").Replace(text)
+}
+
+// Render returns the generated part of the map note, markers included. It
+// carries no date, so an unchanged vault renders the same and Write leaves
+// the note alone.
+func (m *VaultMap) Render() string {
+	var b strings.Builder
+	b.WriteString(mapStart + "\n")
+	fmt.Fprintf(&b, "_Generated by `note-gpt map` from %d notes. Everything between the map markers is replaced when it runs again._\n",
+		m.Notes)
+	for _, topic := range m.Topics {
+		fmt.Fprintf(&b, "\n## %s\n\n", topic.Label)
+		if topic.Description != "" {
+			b.WriteString(topic.Description + "\n\n")
+		}
+		for _, note := range topic.Notes {
+			b.WriteString("- " + wikilink(note.Path) + "\n")
+		}
+	}
+	b.WriteString(mapEnd + "\n")
+	return b.String()
+}
+
+// wikilink links to the note at a vault-relative path by its full path, so
+// it can't resolve to another note of the same name
+func wikilink(path string) string {
+	target := strings.TrimSuffix(path, ".md")
+	if title := notescore.NoteTitle(path); title != target {
+		return "[[" + target + "|" + title + "]]"
+	}
+	return "[[" + target + "]]"
+}
+
+// Write puts the map into the map note. A note that already holds a map has
+// it replaced in place, a note without one gets it appended, and a missing
+// note is created. It reports whether the file changed.
+func (m *VaultMap) Write() (bool, error) {
+	path, err := vaultFile(m.Source, m.Note)
+	if err != nil {
+		return false, err
+	}
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	updated := spliceMap(existing, m.Render(), notescore.NoteTitle(m.Note))
+	if err == nil && bytes.Equal(existing, updated) {
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, err
+	}
+	if err := notescore.WriteFileAtomic(path, updated); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// spliceMap returns content with the generated part replaced by generated,
+// leaving whatever was written around it alone
+func spliceMap(content []byte, generated, title string) []byte {
+	if content == nil {
+		return []byte("# " + title + "\n\n" + generated)
+	}
+	start := bytes.Index(content, []byte(mapStart))
+	end := bytes.Index(content, []byte(mapEnd))
+	if start < 0 || end < start {
+		trimmed := bytes.TrimRight(content, "\n")
+		return append(append(trimmed, "\n\n"...), generated...)
+	}
+	end += len(mapEnd)
+	if end < len(content) && content[end] == '\n' {
+		end++
+	}
+	var updated []byte
+	updated = append(updated, content[:start]...)
+	updated = append(updated, generated...)
+	return append(updated, content[end:]...)
+}
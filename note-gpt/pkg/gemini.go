@@ -3,6 +3,9 @@ package pkg
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"notescore"
+	"time"
 
 	"github.com/google/generative-ai-go/genai"
 	"google.golang.org/api/option"
@@ -13,14 +16,14 @@ type GeminiClient struct {
 	model  *genai.GenerativeModel
 }
 
-func NewGeminiClient(apiKey string) (*GeminiClient, error) {
+func NewGeminiClient(apiKey string, modelName string) (*GeminiClient, error) {
 	ctx := context.Background()
 	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Gemini client: %w", err)
 	}
 
-	model := client.GenerativeModel("gemini-2.5-flash-lite")
+	model := client.GenerativeModel(modelName)
 
 	return &GeminiClient{
 		client: client,
@@ -31,7 +34,9 @@ func NewGeminiClient(apiKey string) (*GeminiClient, error) {
 func (g *GeminiClient) GenerateResponse(prompt string) (string, error) {
 	ctx := context.Background()
 
+	start := time.Now()
 	resp, err := g.model.GenerateContent(ctx, genai.Text(prompt))
+	slog.Debug("Generated response", "prompt", prompt, notescore.KeyDuration, time.Since(start))
 	if err != nil {
 		return "", fmt.Errorf("failed to generate content: %w", err)
 	}
@@ -47,6 +52,12 @@ func (g *GeminiClient) GenerateResponse(prompt string) (string, error) {
 	return "", fmt.Errorf("unexpected response format")
 }
 
+// Ping checks the API key and model name by fetching the model's info
+func (g *GeminiClient) Ping(ctx context.Context) error {
+	_, err := g.model.Info(ctx)
+	return err
+}
+
 func (g *GeminiClient) Close() error {
 	return g.client.Close()
 }
@@ -0,0 +1,49 @@
+package pkg
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// HashEmbedding is a deterministic, offline embedder using the hashing trick
+// over lowercased words. It is only meant for evaluation runs and tests where
+// no embedding server is available.
+type HashEmbedding struct {
+	dimension int
+}
+
+func NewHashEmbedding(dimension int) *HashEmbedding {
+	return &HashEmbedding{dimension: dimension}
+}
+
+func (e *HashEmbedding) Vectorize(ctx context.Context, text string) ([]float32, error) {
+	vector := make([]float32, e.dimension)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for _, word := range words {
+		h := fnv.New32a()
+		h.Write([]byte(word))
+		sum := h.Sum32()
+		sign := float32(1)
+		if sum&1 == 1 {
+			sign = -1
+		}
+		vector[int(sum>>1)%e.dimension] += sign
+	}
+
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v * v)
+	}
+	if norm > 0 {
+		scale := float32(1 / math.Sqrt(norm))
+		for i := range vector {
+			vector[i] *= scale
+		}
+	}
+	return vector, nil
+}
@@ -0,0 +1,201 @@
+package pkg
+
+import (
+	"context"
+	"io/fs"
+	"math"
+	"notescore"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pinecone-io/go-pinecone/v4/pinecone"
+)
+
+// LocalVector is an in-memory vector store built directly from a notes
+// directory. It mirrors the ids and metadata vector-sync writes to Pinecone
+// so it can stand in for Vector in offline evaluation runs.
+type LocalVector struct {
+	embedder notescore.Embedder
+	input    notescore.Input
+	records  []*pinecone.Vector
+}
+
+func NewLocalVector(embedder notescore.Embedder, input notescore.Input) *LocalVector {
+	return &LocalVector{embedder: embedder, input: input}
+}
+
+// LoadNotes chunks and embeds every .md file under notesDir, recording paths
+// relative to notesDir along with the vault ID, source name and links
+func (v *LocalVector) LoadNotes(ctx context.Context, notesDir, vaultID, source string) error {
+	notes := make(map[string][]byte)
+	var paths []string
+	err := filepath.WalkDir(notesDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".md") {
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(notesDir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		notes[rel] = content
+		paths = append(paths, rel)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	links := resolveLinks(notes)
+	for _, path := range paths {
+		if err := v.addFile(ctx, path, vaultID, source, notes[path], links[path]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveLinks returns the links and backlink count of each note the way
+// vector-sync indexes them
+func resolveLinks(notes map[string][]byte) map[string]notescore.LinkInfo {
+	resolver := notescore.NewLinkResolver()
+	for path, content := range notes {
+		resolver.Add(path, notescore.ParseFrontMatter(content).Aliases)
+	}
+	out := make(map[string][]string)
+	backlinks := make(map[string]int)
+	for path, content := range notes {
+		seen := make(map[string]bool)
+		for _, target := range notescore.ParseLinks(content) {
+			resolved, ok := resolver.Resolve(path, target)
+			if ok && resolved != path && !seen[resolved] {
+				seen[resolved] = true
+				out[path] = append(out[path], resolved)
+				backlinks[resolved]++
+			}
+		}
+	}
+	links := make(map[string]notescore.LinkInfo)
+	for path := range notes {
+		sort.Strings(out[path])
+		if len(out[path]) > notescore.MaxMetadataLinks {
+			out[path] = out[path][:notescore.MaxMetadataLinks]
+		}
+		links[path] = notescore.LinkInfo{Links: out[path], Backlinks: backlinks[path]}
+	}
+	return links
+}
+
+func (v *LocalVector) addFile(ctx context.Context, relPath, vaultID, source string, content []byte, links notescore.LinkInfo) error {
+	fileId := notescore.FileId(vaultID, relPath)
+	for _, chunk := range notescore.ChunkMarkdown(content, notescore.MaxChunkChars) {
+		text, _ := v.input.Document(relPath, chunk)
+		values, err := v.embedder.Vectorize(ctx, text)
+		if err != nil {
+			return err
+		}
+		chunkMetadata := notescore.NewChunkMetadata(chunk, relPath, vaultID, source, "")
+		chunkMetadata.LinkInfo = links
+		metadata, err := chunkMetadata.Struct()
+		if err != nil {
+			return err
+		}
+		v.records = append(v.records, &pinecone.Vector{
+			Id:       notescore.ChunkId(fileId, chunk.Index),
+			Values:   &values,
+			Metadata: metadata,
+		})
+	}
+	return nil
+}
+
+func (v *LocalVector) Query(ctx context.Context, queryText []byte, topK int) ([]*pinecone.ScoredVector, error) {
+	values, err := v.EmbedQuery(ctx, queryText)
+	if err != nil {
+		return nil, err
+	}
+	return v.search(values, topK, nil), nil
+}
+
+// EmbedQuery embeds queryText as a search query
+func (v *LocalVector) EmbedQuery(ctx context.Context, queryText []byte) ([]float32, error) {
+	return v.embedder.Vectorize(ctx, v.input.Query(string(queryText)))
+}
+
+// QueryValues returns the topK vectors closest to values
+func (v *LocalVector) QueryValues(ctx context.Context, values []float32, topK int) ([]*pinecone.ScoredVector, error) {
+	return v.search(values, topK, nil), nil
+}
+
+// QueryValuesNotes is QueryValues restricted to the chunks of the notes
+// filter matches
+func (v *LocalVector) QueryValuesNotes(ctx context.Context, values []float32, topK int, filter notescore.NoteFilter) ([]*pinecone.ScoredVector, error) {
+	if filter.Empty() {
+		return nil, nil
+	}
+	return v.search(values, topK, &filter), nil
+}
+
+// FetchFile returns the vectors of the file with the given id in chunk
+// order. There is a single namespace, so namespace is ignored.
+func (v *LocalVector) FetchFile(ctx context.Context, namespace, fileId string) ([]*pinecone.Vector, error) {
+	var vectors []*pinecone.Vector
+	for _, record := range v.records {
+		if strings.HasPrefix(record.Id, fileId) {
+			vectors = append(vectors, record)
+		}
+	}
+	notescore.SortChunks(vectors)
+	return vectors, nil
+}
+
+// FetchNamespace returns every vector in the store. There is a single
+// namespace, so namespace is ignored.
+func (v *LocalVector) FetchNamespace(ctx context.Context, namespace string) ([]*pinecone.Vector, error) {
+	return append([]*pinecone.Vector(nil), v.records...), nil
+}
+
+func (v *LocalVector) search(query []float32, topK int, filter *notescore.NoteFilter) []*pinecone.ScoredVector {
+	var matches []*pinecone.ScoredVector
+	for _, record := range v.records {
+		if filter != nil && !filter.Match(notescore.ParseChunkMetadata(record.Metadata.AsMap())) {
+			continue
+		}
+		matches = append(matches, &pinecone.ScoredVector{
+			Vector: record,
+			Score:  cosine(query, *record.Values),
+		})
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	if len(matches) > topK {
+		matches = matches[:topK]
+	}
+	return matches
+}
+
+func cosine(a, b []float32) float32 {
+	var dot, normA, normB float64
+	for i := range a {
+		if i >= len(b) {
+			break
+		}
+		dot += float64(a[i] * b[i])
+		normA += float64(a[i] * a[i])
+		normB += float64(b[i] * b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return float32(dot / (math.Sqrt(normA) * math.Sqrt(normB)))
+}
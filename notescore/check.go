@@ -0,0 +1,91 @@
+package notescore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// CheckTimeout bounds each probe of `config check`
+const CheckTimeout = 10 * time.Second
+
+// Check is one line of `config check`: a name and a probe that returns a
+// detail to show when it passes
+type Check struct {
+	Name  string
+	Probe func(ctx context.Context) (string, error)
+}
+
+// RunChecks prints the profile of config and then runs checks in order, each
+// under CheckTimeout, printing a line per check. It reports whether every
+// check passed.
+func RunChecks(config *Config, checks ...Check) bool {
+	if config.Profile != "" {
+		fmt.Printf("Profile: %s\n", config.Profile)
+	}
+	passed := true
+	for _, check := range checks {
+		ctx, cancel := context.WithTimeout(context.Background(), CheckTimeout)
+		detail, err := check.Probe(ctx)
+		cancel()
+		if err != nil {
+			passed = false
+			fmt.Printf("FAIL  %-16s %v\n", check.Name, err)
+			continue
+		}
+		fmt.Printf("ok    %-16s %s\n", check.Name, detail)
+	}
+	return passed
+}
+
+// SourceChecks checks that the directory of every source exists
+func SourceChecks(config *Config) []Check {
+	var checks []Check
+	for _, source := range config.AllSources() {
+		checks = append(checks, Check{Name: "source " + source.Name, Probe: func(ctx context.Context) (string, error) {
+			info, err := os.Stat(source.Path)
+			if err != nil {
+				return "", err
+			}
+			if !info.IsDir() {
+				return "", fmt.Errorf("%s is not a directory", source.Path)
+			}
+			return source.Path, nil
+		}})
+	}
+	return checks
+}
+
+// IndexChecks checks that the embedder answers and that the vector store is
+// reachable with an index of the dimension the embedder returns. They must
+// run in order, the second uses the dimension the first found.
+func IndexChecks(config *Config) []Check {
+	embedder := NewEmbedding(config.Embedder)
+	var embeddingDimension int
+	return []Check{
+		{Name: "embedder", Probe: func(ctx context.Context) (string, error) {
+			vector, err := embedder.Vectorize(ctx, "ping")
+			if err != nil {
+				return "", err
+			}
+			embeddingDimension = len(vector)
+			return fmt.Sprintf("%s at %s, dimension %d", config.Embedder.Model, config.Embedder.URL, embeddingDimension), nil
+		}},
+		{Name: "vector store", Probe: func(ctx context.Context) (string, error) {
+			vectorDb, err := NewVector(config.VectorStore.APIKey, config.VectorStore.Host, config.VectorStore.Index, embedder)
+			if err != nil {
+				return "", err
+			}
+			defer vectorDb.Close()
+			dimension, count, err := vectorDb.Ping(ctx)
+			if err != nil {
+				return "", err
+			}
+			if embeddingDimension > 0 && dimension > 0 && int(dimension) != embeddingDimension {
+				return "", fmt.Errorf("index %s has dimension %d but the embedder returns %d", config.VectorStore.Index, dimension, embeddingDimension)
+			}
+			return fmt.Sprintf("index %s, dimension %d, %d vectors", config.VectorStore.Index, dimension, count), nil
+		}},
+	}
+}
@@ -0,0 +1,101 @@
+package notescore
+
+import (
+	"strings"
+)
+
+const MaxChunkChars = 1500
+
+// Chunk is a contiguous range of lines from a note that is embedded as its own vector
+type Chunk struct {
+	Index     int
+	StartLine int    // 1-based, inclusive
+	EndLine   int    // 1-based, inclusive
+	Heading   string // Heading path the chunk sits under, e.g. "Setup > Install"
+	Text      string
+}
+
+// ChunkMarkdown splits a Markdown note into chunks at headings, further
+// splitting long sections at blank lines so no chunk is much larger than maxChars
+func ChunkMarkdown(content []byte, maxChars int) []Chunk {
+	lines := strings.Split(string(content), "\n")
+	var chunks []Chunk
+	var headings [6]string
+
+	start, size, lastBlank := 0, 0, -1
+	inFence := false
+
+	flush := func(end int) {
+		s, e := start, end
+		for s < e && strings.TrimSpace(lines[s]) == "" {
+			s++
+		}
+		for e > s && strings.TrimSpace(lines[e-1]) == "" {
+			e--
+		}
+		if s < e {
+			chunks = append(chunks, Chunk{
+				Index:     len(chunks),
+				StartLine: s + 1,
+				EndLine:   e,
+				Heading:   headingPath(headings),
+				Text:      strings.Join(lines[s:e], "\n"),
+			})
+		}
+		start = end
+		size = 0
+	}
+
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inFence = !inFence
+		}
+
+		if level, title := parseHeading(line); !inFence && level > 0 {
+			flush(i)
+			headings[level-1] = title
+			for j := level; j < len(headings); j++ {
+				headings[j] = ""
+			}
+		} else if size > 0 && size+len(line)+1 > maxChars {
+			if lastBlank > start {
+				flush(lastBlank + 1)
+				for _, l := range lines[start:i] {
+					size += len(l) + 1
+				}
+			} else {
+				flush(i)
+			}
+		}
+
+		if trimmed == "" {
+			lastBlank = i
+		}
+		size += len(line) + 1
+	}
+	flush(len(lines))
+
+	return chunks
+}
+
+func parseHeading(line string) (int, string) {
+	level := 0
+	for level < len(line) && line[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 || level >= len(line) || line[level] != ' ' {
+		return 0, ""
+	}
+	return level, strings.TrimSpace(line[level:])
+}
+
+func headingPath(headings [6]string) string {
+	var parts []string
+	for _, h := range headings {
+		if h != "" {
+			parts = append(parts, h)
+		}
+	}
+	return strings.Join(parts, " > ")
+}
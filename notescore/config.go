@@ -0,0 +1,510 @@
+package notescore
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is one profile of the shared vector-notes config file, after
+// environment variables and command-line flags have been applied on top.
+// vector-sync ignores the llm and retrieval sections and note-gpt the sync
+// and metrics sections.
+type Config struct {
+	Profile     string            `yaml:"-"`
+	Vault       VaultConfig       `yaml:"vault"`
+	Sources     []SourceConfig    `yaml:"sources"`
+	VectorStore VectorStoreConfig `yaml:"vector_store"`
+	Embedder    EmbedderConfig    `yaml:"embedder"`
+	LLM         LLMConfig         `yaml:"llm"`
+	Retrieval   RetrievalConfig   `yaml:"retrieval"`
+	Sync        SyncConfig        `yaml:"sync"`
+	Metrics     MetricsConfig     `yaml:"metrics"`
+	Log         LogConfig         `yaml:"log"`
+}
+
+type VaultConfig struct {
+	Path   string   `yaml:"path"`
+	Ignore []string `yaml:"ignore"`
+}
+
+// SourceConfig is one notes directory synced into its own vector namespace.
+// When no sources are configured, vault is used as a single source named
+// "default" in the index's default namespace.
+type SourceConfig struct {
+	Name      string   `yaml:"name"`
+	Path      string   `yaml:"path"`
+	Namespace string   `yaml:"namespace"`
+	Ignore    []string `yaml:"ignore"` // Patterns of vault-relative paths to skip
+}
+
+type VectorStoreConfig struct {
+	APIKey string `yaml:"api_key"`
+	Host   string `yaml:"host"`
+	Index  string `yaml:"index"`
+}
+
+type EmbedderConfig struct {
+	URL        string                `yaml:"url"`
+	Model      string                `yaml:"model"`
+	Timeout    time.Duration         `yaml:"timeout"`     // Per request
+	MaxRetries int                   `yaml:"max_retries"` // Retries of transient failures
+	Title      bool                  `yaml:"title"`       // Embed chunks with their note title and heading path
+	Models     map[string]ModelInput `yaml:"models"`      // Per-model input settings, replacing the built-in ones
+}
+
+type LLMConfig struct {
+	APIKey string `yaml:"api_key"`
+	Model  string `yaml:"model"`
+}
+
+type RetrievalConfig struct {
+	Sources       []string `yaml:"sources"` // Source names to query, empty for all
+	TopK          int      `yaml:"top_k"`
+	Rewrite       bool     `yaml:"rewrite"`        // Condense follow-up questions into standalone queries
+	HyDE          bool     `yaml:"hyde"`           // Also search with a hypothetical answer to the query
+	FanOut        int      `yaml:"fan_out"`        // Number of query variants to search with
+	CitationLinks string   `yaml:"citation_links"` // "file" for file:// URIs, "obsidian" for obsidian:// URIs
+	GraphDepth    int      `yaml:"graph_depth"`    // Link hops followed from the top matches, 0 to not follow links
+	GraphNotes    int      `yaml:"graph_notes"`    // Most linked notes added to the top matches
+	GraphDecay    float64  `yaml:"graph_decay"`    // Score multiplier per link hop
+	ContextTokens int      `yaml:"context_tokens"` // Budget linked notes must fit in, with the top matches
+	Mentions      bool     `yaml:"mentions"`       // Add notes the query names by alias or #tag
+	MentionNotes  int      `yaml:"mention_notes"`  // Most notes mentions add
+	Inbox         string   `yaml:"inbox"`          // Vault folder /save writes answers into
+}
+
+type SyncConfig struct {
+	Interval        time.Duration `yaml:"interval"`         // How often the client tree is compared with the server tree
+	StateDir        string        `yaml:"state_dir"`        // Where each vault's state store is kept
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"` // How long in-flight files may take to finish on shutdown
+}
+
+// MetricsConfig configures the daemon's HTTP listener for health checks and
+// Prometheus metrics
+type MetricsConfig struct {
+	Listen string `yaml:"listen"` // Address such as ":9090", empty to disable
+}
+
+// DefaultSourceName names the source built from vault.path
+const DefaultSourceName = "default"
+
+// Services are the remote services a command needs configured
+type Services int
+
+const (
+	IndexServices Services = 1 << iota // Pinecone and the embedding server
+	LLMService                         // Gemini
+)
+
+// configFile is the on-disk layout: a set of named profiles and the one used
+// when no profile is selected
+type configFile struct {
+	DefaultProfile string               `yaml:"default_profile"`
+	Profiles       map[string]yaml.Node `yaml:"profiles"`
+}
+
+// Flags are the command-line overrides shared by every command.
+// Empty values leave the file and environment settings untouched.
+type Flags struct {
+	ConfigPath string
+	Profile    string
+	NotesDir   string
+	Index      string
+	Sources    string // Only registered by RegisterSources
+}
+
+func (f *Flags) Register(fs *flag.FlagSet) {
+	fs.StringVar(&f.ConfigPath, "config", "", "config file (default $VECTOR_NOTES_CONFIG, ./vector-notes.yaml or ~/.config/vector-notes/config.yaml)")
+	fs.StringVar(&f.Profile, "profile", "", "config profile (default $VECTOR_NOTES_PROFILE or the file's default_profile)")
+	fs.StringVar(&f.NotesDir, "notes", "", "notes directory, overrides vault.path and sources")
+	fs.StringVar(&f.Index, "index", "", "vector index name, overrides vector_store.index")
+}
+
+// RegisterSources adds the -sources flag of commands that query the index
+func (f *Flags) RegisterSources(fs *flag.FlagSet) {
+	fs.StringVar(&f.Sources, "sources", "", "comma-separated source names to query, or \"all\"")
+}
+
+// DefaultConfig returns the settings used for anything the config file,
+// environment and flags leave unset
+func DefaultConfig() *Config {
+	return &Config{
+		VectorStore: VectorStoreConfig{Index: "joyful-elm"},
+		Embedder: EmbedderConfig{
+			URL:        "http://localhost:8000/embed",
+			Model:      "nomic-embed-text",
+			Timeout:    30 * time.Second,
+			MaxRetries: 3,
+			Title:      true,
+		},
+		LLM: LLMConfig{Model: "gemini-2.5-flash-lite"},
+		Retrieval: RetrievalConfig{
+			TopK:          2,
+			Rewrite:       true,
+			FanOut:        1,
+			CitationLinks: "file",
+			GraphNotes:    3,
+			GraphDecay:    0.8,
+			ContextTokens: 6000,
+			Mentions:      true,
+			MentionNotes:  3,
+			Inbox:         "Inbox",
+		},
+		Sync: SyncConfig{
+			Interval:        5 * time.Second,
+			StateDir:        DefaultStateDir(),
+			ShutdownTimeout: 30 * time.Second,
+		},
+		Log: LogConfig{Level: "info", Format: "text", Output: "stderr"},
+	}
+}
+
+// DefaultStateDir is where state is kept when sync.state_dir is not set
+func DefaultStateDir() string {
+	if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, "vector-notes", "state")
+	}
+	return ".server"
+}
+
+// LoadConfig builds the configuration from, in increasing precedence, the
+// defaults, the selected profile of the config file, .env and environment
+// variables, and flags, then sets up logging as it says. services are
+// required to be configured, and checks validate the sections only one
+// command uses. All validation problems are returned together.
+func LoadConfig(flags Flags, services Services, checks ...func(*Config) []error) (*Config, error) {
+	// Try to load .env file (optional - don't fail if it doesn't exist)
+	if err := godotenv.Load(); err != nil {
+		slog.Debug(".env file not found, using environment variables only")
+	}
+
+	config, err := loadConfigFile(flags)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	config.applyEnv(&errs)
+	config.applyFlags(flags)
+	errs = append(errs, config.validate(services)...)
+	for _, check := range checks {
+		errs = append(errs, check(config)...)
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("config validation failed:\n%w", errors.Join(errs...))
+	}
+	if err := SetupLogging(config.Log, config.VectorStore.APIKey, config.LLM.APIKey); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func loadConfigFile(flags Flags) (*Config, error) {
+	path := flags.ConfigPath
+	if path == "" {
+		path = os.Getenv("VECTOR_NOTES_CONFIG")
+	}
+	explicit := path != ""
+	if !explicit {
+		path = findConfigFile()
+	}
+	if path == "" {
+		return DefaultConfig(), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !explicit && os.IsNotExist(err) {
+			return DefaultConfig(), nil
+		}
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var file configFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	profile := flags.Profile
+	if profile == "" {
+		profile = os.Getenv("VECTOR_NOTES_PROFILE")
+	}
+	if profile == "" {
+		profile = file.DefaultProfile
+	}
+	if profile == "" && len(file.Profiles) == 1 {
+		for name := range file.Profiles {
+			profile = name
+		}
+	}
+	if _, ok := file.Profiles[profile]; !ok {
+		return nil, fmt.Errorf("profile %q not found in %s", profile, path)
+	}
+
+	// Decode the profile on top of the defaults so unset keys keep their
+	// default values
+	config := DefaultConfig()
+	node := file.Profiles[profile]
+	if err := node.Decode(config); err != nil {
+		return nil, fmt.Errorf("failed to parse profile %q in %s: %w", profile, path, err)
+	}
+	config.Profile = profile
+	return config, nil
+}
+
+func findConfigFile() string {
+	candidates := []string{"vector-notes.yaml"}
+	if dir, err := os.UserConfigDir(); err == nil {
+		candidates = append(candidates, filepath.Join(dir, "vector-notes", "config.yaml"))
+	}
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return ""
+}
+
+func (c *Config) applyEnv(errs *[]error) {
+	envString("PINECONE_API_KEY", &c.VectorStore.APIKey)
+	envString("PINECONE_HOST", &c.VectorStore.Host)
+	envString("PINECONE_INDEX", &c.VectorStore.Index)
+	envString("NOTES_DIR", &c.Vault.Path)
+	envString("EMBEDDING_URL", &c.Embedder.URL)
+	envString("EMBEDDING_MODEL", &c.Embedder.Model)
+	envDuration("EMBEDDING_TIMEOUT", &c.Embedder.Timeout, errs)
+	envInt("EMBEDDING_RETRIES", &c.Embedder.MaxRetries, errs)
+	envBool("EMBEDDING_TITLE", &c.Embedder.Title, errs)
+	envString("GEMINI_API_KEY", &c.LLM.APIKey)
+	envString("GEMINI_MODEL", &c.LLM.Model)
+	envInt("TOP_K", &c.Retrieval.TopK, errs)
+	envBool("QUERY_REWRITE", &c.Retrieval.Rewrite, errs)
+	envBool("QUERY_HYDE", &c.Retrieval.HyDE, errs)
+	envInt("QUERY_FANOUT", &c.Retrieval.FanOut, errs)
+	envString("CITATION_LINKS", &c.Retrieval.CitationLinks)
+	envInt("QUERY_GRAPH_DEPTH", &c.Retrieval.GraphDepth, errs)
+	envInt("QUERY_GRAPH_NOTES", &c.Retrieval.GraphNotes, errs)
+	envFloat("QUERY_GRAPH_DECAY", &c.Retrieval.GraphDecay, errs)
+	envInt("CONTEXT_TOKENS", &c.Retrieval.ContextTokens, errs)
+	envBool("QUERY_MENTIONS", &c.Retrieval.Mentions, errs)
+	envInt("QUERY_MENTION_NOTES", &c.Retrieval.MentionNotes, errs)
+	envString("ANSWER_INBOX", &c.Retrieval.Inbox)
+	envDuration("SYNC_INTERVAL", &c.Sync.Interval, errs)
+	envString("STATE_DIR", &c.Sync.StateDir)
+	envDuration("SHUTDOWN_TIMEOUT", &c.Sync.ShutdownTimeout, errs)
+	envString("METRICS_LISTEN", &c.Metrics.Listen)
+	envString("LOG_LEVEL", &c.Log.Level)
+	envString("LOG_FORMAT", &c.Log.Format)
+	envString("LOG_OUTPUT", &c.Log.Output)
+	envBool("LOG_DEBUG_CONTENT", &c.Log.DebugContent, errs)
+}
+
+func (c *Config) applyFlags(flags Flags) {
+	if flags.NotesDir != "" {
+		c.Vault.Path = flags.NotesDir
+		c.Sources = nil
+	}
+	if flags.Index != "" {
+		c.VectorStore.Index = flags.Index
+	}
+	if flags.Sources != "" {
+		c.Retrieval.Sources = ParseSourceList(flags.Sources)
+	}
+}
+
+func (c *Config) validate(services Services) []error {
+	var errs []error
+	if len(c.Sources) == 0 && c.Vault.Path == "" {
+		errs = append(errs, fmt.Errorf("vault.path (NOTES_DIR) or sources is required"))
+	}
+	names := make(map[string]bool)
+	namespaces := make(map[string]bool)
+	for i, source := range c.Sources {
+		if source.Name == "" {
+			errs = append(errs, fmt.Errorf("sources[%d].name is required", i))
+		} else if names[source.Name] {
+			errs = append(errs, fmt.Errorf("sources[%d].name %q is used more than once", i, source.Name))
+		}
+		if source.Path == "" {
+			errs = append(errs, fmt.Errorf("sources[%d].path is required", i))
+		}
+		if namespaces[source.Namespace] {
+			errs = append(errs, fmt.Errorf("sources[%d].namespace %q is used more than once", i, source.Namespace))
+		}
+		names[source.Name] = true
+		namespaces[source.Namespace] = true
+	}
+	if services&IndexServices != 0 {
+		if c.VectorStore.APIKey == "" {
+			errs = append(errs, fmt.Errorf("vector_store.api_key (PINECONE_API_KEY) is required"))
+		}
+		if c.VectorStore.Host == "" {
+			errs = append(errs, fmt.Errorf("vector_store.host (PINECONE_HOST) is required"))
+		}
+		if c.Embedder.URL == "" {
+			errs = append(errs, fmt.Errorf("embedder.url (EMBEDDING_URL) is required"))
+		}
+	}
+	if c.Embedder.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("embedder.timeout (EMBEDDING_TIMEOUT) must be positive"))
+	}
+	if c.Embedder.MaxRetries < 0 {
+		errs = append(errs, fmt.Errorf("embedder.max_retries (EMBEDDING_RETRIES) must not be negative"))
+	}
+	for model, input := range c.Embedder.Models {
+		if input.MaxTokens < 0 {
+			errs = append(errs, fmt.Errorf("embedder.models.%s.max_tokens must not be negative", model))
+		}
+	}
+	if services&LLMService != 0 && c.LLM.APIKey == "" {
+		errs = append(errs, fmt.Errorf("llm.api_key (GEMINI_API_KEY) is required"))
+	}
+	return errs
+}
+
+// AllSources returns the configured sources, or the vault as the single
+// "default" source when none are configured
+func (c *Config) AllSources() []SourceConfig {
+	if len(c.Sources) > 0 {
+		return c.Sources
+	}
+	return []SourceConfig{{
+		Name:   DefaultSourceName,
+		Path:   c.Vault.Path,
+		Ignore: c.Vault.Ignore,
+	}}
+}
+
+// Namespaces maps source names to their vector namespaces. An empty list
+// selects every source.
+func (c *Config) Namespaces(names []string) ([]string, error) {
+	sources := c.AllSources()
+	if len(names) == 0 {
+		names = make([]string, 0, len(sources))
+		for _, source := range sources {
+			names = append(names, source.Name)
+		}
+	}
+
+	var namespaces []string
+	for _, name := range names {
+		found := false
+		for _, source := range sources {
+			if source.Name == name {
+				namespaces = append(namespaces, source.Namespace)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown source %q", name)
+		}
+	}
+	return namespaces, nil
+}
+
+// SourceNamed returns the configured source called name
+func (c *Config) SourceNamed(name string) (SourceConfig, bool) {
+	for _, source := range c.AllSources() {
+		if source.Name == name {
+			return source, true
+		}
+	}
+	return SourceConfig{}, false
+}
+
+// SourceFor returns the source whose directory contains path
+func (c *Config) SourceFor(path string) (SourceConfig, bool) {
+	for _, source := range c.AllSources() {
+		rel, err := filepath.Rel(source.Path, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return source, true
+		}
+	}
+	return SourceConfig{}, false
+}
+
+// ParseSourceList parses a comma-separated list of source names, where "all"
+// or an empty string selects every source
+func ParseSourceList(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "all" {
+			return nil
+		}
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func envString(key string, dst *string) {
+	if value := os.Getenv(key); value != "" {
+		*dst = value
+	}
+}
+
+func envBool(key string, dst *bool, errs *[]error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s: %q is not a boolean", key, value))
+		return
+	}
+	*dst = parsed
+}
+
+func envInt(key string, dst *int, errs *[]error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s: %q is not an integer", key, value))
+		return
+	}
+	*dst = parsed
+}
+
+func envFloat(key string, dst *float64, errs *[]error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s: %q is not a number", key, value))
+		return
+	}
+	*dst = parsed
+}
+
+func envDuration(key string, dst *time.Duration, errs *[]error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s: %q is not a duration", key, value))
+		return
+	}
+	*dst = parsed
+}
@@ -0,0 +1,272 @@
+package notescore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	embeddingDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "notescore_embedding_duration_seconds",
+		Help:    "Time taken by one embedding request.",
+		Buckets: DurationBuckets,
+	}, []string{"model"})
+	embeddingErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "notescore_embedding_errors_total",
+		Help: "Embedding requests that failed, by error type.",
+	}, []string{"type"})
+	embeddingRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "notescore_embedding_retries_total",
+		Help: "Embedding requests retried after a transient failure.",
+	})
+	embeddingTruncated = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "notescore_embedding_truncated_total",
+		Help: "Chunks cut short to fit the embedding model's input limit.",
+	})
+)
+
+// Retry backoff of the embedding client. A Retry-After header overrides the
+// backoff up to maxRetryAfter.
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 10 * time.Second
+	maxRetryAfter  = time.Minute
+)
+
+// maxErrorBody is how much of an error response is read for its message
+const maxErrorBody = 4096
+
+// Embedder turns text into a vector
+type Embedder interface {
+	Vectorize(ctx context.Context, text string) ([]float32, error)
+}
+
+// Embedding is an Embedder backed by an Ollama-compatible embedding server
+type Embedding struct {
+	httpClient   *http.Client
+	embeddingUrl string
+	model        string
+	timeout      time.Duration // Per request, retries get a fresh one
+	maxRetries   int
+	input        Input
+}
+
+type EmbedRequest struct {
+	Model string `json:"model"`
+	Input string `json:"input"`
+}
+
+type EmbedResponse struct {
+	Embeddings [][]float32 `json:"embeddings"`
+}
+
+// EmbeddingError is an error response from the embedding server
+type EmbeddingError struct {
+	StatusCode int
+	Message    string        // Taken from the response body
+	RetryAfter time.Duration // From the Retry-After header, if any
+}
+
+func (e *EmbeddingError) Error() string {
+	msg := fmt.Sprintf("embedding server returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// Temporary reports whether the request may succeed when retried
+func (e *EmbeddingError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// DimensionError is returned when an embedding doesn't fit the index
+type DimensionError struct {
+	Model string
+	Got   int
+	Want  int
+}
+
+func (e *DimensionError) Error() string {
+	return fmt.Sprintf("%s returned a %d-dimensional vector but the index has dimension %d", e.Model, e.Got, e.Want)
+}
+
+// NewEmbedding returns a client for the embedding server config describes
+func NewEmbedding(config EmbedderConfig) *Embedding {
+	return &Embedding{
+		httpClient:   &http.Client{},
+		embeddingUrl: config.URL,
+		model:        config.Model,
+		timeout:      config.Timeout,
+		maxRetries:   config.MaxRetries,
+		input:        NewInput(config),
+	}
+}
+
+// Model is the name of the embedding model requested from the server
+func (e *Embedding) Model() string {
+	return e.model
+}
+
+// Input is how text is prepared for the model before it is embedded
+func (e *Embedding) Input() Input {
+	return e.input
+}
+
+// Version identifies the vectors e makes: the model, and the input settings
+// when documents are prepared before embedding
+func (e *Embedding) Version() string {
+	if signature := e.input.Signature(); signature != "" {
+		return e.model + "@" + signature
+	}
+	return e.model
+}
+
+// Close releases the embedder's idle connections
+func (e *Embedding) Close() {
+	e.httpClient.CloseIdleConnections()
+}
+
+// Vectorize embeds text, retrying transient failures such as 5xx and 429
+// responses, refused connections and timed out requests
+func (e *Embedding) Vectorize(ctx context.Context, text string) ([]float32, error) {
+	start := time.Now()
+	values, err := e.vectorize(ctx, text)
+	for attempt := 1; err != nil && attempt <= e.maxRetries; attempt++ {
+		delay, ok := retryDelay(ctx, err, attempt)
+		if !ok {
+			break
+		}
+		embeddingRetries.Inc()
+		Logger(ctx).Warn("Embedding request failed, retrying", KeyAttempt, attempt, "delay", delay, KeyErr, err)
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-time.After(delay):
+			values, err = e.vectorize(ctx, text)
+		}
+	}
+	if err != nil {
+		embeddingErrors.WithLabelValues(ErrorType(err)).Inc()
+		return nil, err
+	}
+	ObserveSince(embeddingDuration.WithLabelValues(e.model), start)
+	return values, nil
+}
+
+func (e *Embedding) vectorize(ctx context.Context, text string) ([]float32, error) {
+	body, err := json.Marshal(EmbedRequest{
+		Model: e.model,
+		Input: text,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode embedding request: %w", err)
+	}
+
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.embeddingUrl, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, readEmbeddingError(resp)
+	}
+
+	var result EmbedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode embedding response: %w", err)
+	}
+	if len(result.Embeddings) == 0 || len(result.Embeddings[0]) == 0 {
+		return nil, fmt.Errorf("embedding server returned no vector for model %s", e.model)
+	}
+	return result.Embeddings[0], nil
+}
+
+// readEmbeddingError builds the error for a non-2xx response. Ollama sends
+// {"error": "..."} and OpenAI-style servers {"error": {"message": "..."}};
+// anything else is used as the message verbatim.
+func readEmbeddingError(resp *http.Response) *EmbeddingError {
+	e := &EmbeddingError{
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	var body struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal(data, &body) == nil && len(body.Error) > 0 {
+		var message string
+		var detail struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body.Error, &message) == nil {
+			e.Message = message
+			return e
+		}
+		if json.Unmarshal(body.Error, &detail) == nil && detail.Message != "" {
+			e.Message = detail.Message
+			return e
+		}
+	}
+	e.Message = strings.TrimSpace(string(data))
+	return e
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as a date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
+
+// retryDelay returns how long to wait before retrying after err, or false if
+// err is not transient. Attempts are numbered from 1.
+func retryDelay(ctx context.Context, err error, attempt int) (time.Duration, bool) {
+	if ctx.Err() != nil {
+		return 0, false
+	}
+	var embErr *EmbeddingError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &embErr):
+		if !embErr.Temporary() {
+			return 0, false
+		}
+		if embErr.RetryAfter > 0 {
+			return min(embErr.RetryAfter, maxRetryAfter), true
+		}
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr), errors.Is(err, io.ErrUnexpectedEOF):
+		// Timed out, refused or dropped connections
+	default:
+		return 0, false
+	}
+	return min(retryBaseDelay<<(attempt-1), retryMaxDelay), true
+}
@@ -0,0 +1,170 @@
+package notescore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ModelInput is how text is prepared for one embedding model
+type ModelInput struct {
+	DocumentPrefix string `yaml:"document_prefix"` // Task prefix of embedded notes
+	QueryPrefix    string `yaml:"query_prefix"`    // Task prefix of search queries
+	MaxTokens      int    `yaml:"max_tokens"`      // Input is truncated to this, 0 for no limit
+}
+
+// knownModels are the input settings of common Ollama embedding models.
+// embedder.models in the config overrides them.
+var knownModels = map[string]ModelInput{
+	"nomic-embed-text":       {DocumentPrefix: "search_document: ", QueryPrefix: "search_query: ", MaxTokens: 2048},
+	"mxbai-embed-large":      {QueryPrefix: "Represent this sentence for searching relevant passages: ", MaxTokens: 512},
+	"snowflake-arctic-embed": {QueryPrefix: "Represent this sentence for searching relevant passages: ", MaxTokens: 512},
+	"all-minilm":             {MaxTokens: 256},
+	"bge-m3":                 {MaxTokens: 8192},
+}
+
+// Input prepares text before it is embedded: it adds the task prefix the
+// model expects, puts the note title and heading path in front of chunks,
+// and truncates what wouldn't fit the model's context rather than leaving
+// the server to cut it silently.
+type Input struct {
+	ModelInput
+	Title bool // Embed chunks with their note title and heading path
+}
+
+// NewInput returns the input settings for config's model
+func NewInput(config EmbedderConfig) Input {
+	return Input{ModelInput: config.ModelInput(config.Model), Title: config.Title}
+}
+
+// ModelInput returns the input settings of model. A model name with a tag,
+// such as nomic-embed-text:v1.5, falls back to the settings of the bare name.
+func (c EmbedderConfig) ModelInput(model string) ModelInput {
+	bare, _, _ := strings.Cut(model, ":")
+	for _, name := range []string{model, bare} {
+		if input, ok := c.Models[name]; ok {
+			return input
+		}
+	}
+	for _, name := range []string{model, bare} {
+		if input, ok := knownModels[name]; ok {
+			return input
+		}
+	}
+	return ModelInput{}
+}
+
+// Document returns the text embedded for a chunk of the note at notePath,
+// which is relative to the vault, and whether it had to be truncated
+func (in Input) Document(notePath string, chunk Chunk) (string, bool) {
+	header := in.DocumentPrefix
+	if in.Title {
+		title := NoteTitle(notePath)
+		if chunk.Heading != "" {
+			title += " > " + chunk.Heading
+		}
+		header += title + "\n\n"
+	}
+	return in.truncate(header, chunk.Text)
+}
+
+// Query returns the text embedded for a search query
+func (in Input) Query(text string) string {
+	query, _ := in.truncate(in.QueryPrefix, text)
+	return query
+}
+
+// truncate appends as much of text to header as fits in MaxTokens
+func (in Input) truncate(header, text string) (string, bool) {
+	if in.MaxTokens <= 0 {
+		return header + text, false
+	}
+	budget := in.MaxTokens - EstimateTokens(header)
+	truncated := TruncateTokens(text, max(budget, 0))
+	return header + truncated, len(truncated) < len(text)
+}
+
+// Signature identifies how documents are prepared, so vectors embedded with
+// other settings can be told apart. It is empty when documents are embedded
+// as they are.
+func (in Input) Signature() string {
+	if in.DocumentPrefix == "" && !in.Title && in.MaxTokens == 0 {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%q %t %d", in.DocumentPrefix, in.Title, in.MaxTokens)))
+	return hex.EncodeToString(sum[:4])
+}
+
+// NoteTitle is the title of the note at a vault-relative path, its file name
+// without the extension as Obsidian shows it
+func NoteTitle(notePath string) string {
+	return strings.TrimSuffix(path.Base(strings.ReplaceAll(notePath, "\\", "/")), ".md")
+}
+
+// EstimateTokens estimates how many tokens an embedder sees for text. It
+// approximates a WordPiece tokenizer: short words are one token, longer ones
+// one more per five characters, and every punctuation mark and CJK character
+// is a token of its own.
+func EstimateTokens(text string) int {
+	count := 0
+	scanTokens(text, func(int) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+// TruncateTokens returns the longest prefix of text estimated at no more than
+// maxTokens tokens
+func TruncateTokens(text string, maxTokens int) string {
+	count, end := 0, len(text)
+	scanTokens(text, func(start int) bool {
+		if count == maxTokens {
+			end = start
+			return false
+		}
+		count++
+		return true
+	})
+	return text[:end]
+}
+
+// scanTokens calls fn with the byte offset of each estimated token in text
+// until fn returns false
+func scanTokens(text string, fn func(start int) bool) {
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case isWordRune(r) && !isCJK(r):
+			for n := 0; i < len(text); n++ {
+				r, size := utf8.DecodeRuneInString(text[i:])
+				if !isWordRune(r) || isCJK(r) {
+					break
+				}
+				if n%5 == 0 && !fn(i) {
+					return
+				}
+				i += size
+			}
+		default:
+			if !fn(i) {
+				return
+			}
+			i += size
+		}
+	}
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
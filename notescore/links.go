@@ -0,0 +1,284 @@
+package notescore
+
+import (
+	"bytes"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// [[target]], [[target#heading]], [[target^block]], [[target|text]] and
+	// the embedded ![[...]] forms
+	wikiLinkPattern = regexp.MustCompile(`\[\[([^\[\]|#^]*)[^\[\]]*\]\]`)
+	// [text](target) and [text](<target with spaces> "title")
+	markdownLinkPattern = regexp.MustCompile(`\[[^\[\]]*\]\(\s*(<[^<>]*>|[^()\s]+)(?:\s+"[^"]*")?\s*\)`)
+	inlineCodePattern   = regexp.MustCompile("`[^`]*`")
+)
+
+// attachmentExtensions are linked files that are not notes
+var attachmentExtensions = map[string]bool{
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".svg": true, ".webp": true, ".bmp": true,
+	".pdf": true, ".mp3": true, ".wav": true, ".m4a": true, ".ogg": true, ".mp4": true, ".webm": true,
+	".mov": true, ".canvas": true, ".zip": true, ".csv": true,
+}
+
+// ParseLinks returns the notes content links to, as written, in order of
+// first appearance. Headings, block references and display text are
+// dropped, as are links in code, external URLs and links to attachments.
+func ParseLinks(content []byte) []string {
+	var targets []string
+	seen := make(map[string]bool)
+	add := func(target string) {
+		target = strings.TrimSpace(target)
+		if target == "" || seen[target] || attachmentExtensions[strings.ToLower(path.Ext(target))] {
+			return
+		}
+		seen[target] = true
+		targets = append(targets, target)
+	}
+
+	proseLines(content, func(line string) {
+		for _, match := range wikiLinkPattern.FindAllStringSubmatch(line, -1) {
+			add(match[1])
+		}
+		for _, match := range markdownLinkPattern.FindAllStringSubmatch(line, -1) {
+			add(markdownLinkTarget(match[1]))
+		}
+	})
+	return targets
+}
+
+// proseLines calls fn with each line of content outside the front matter and
+// code fences, with inline code removed
+func proseLines(content []byte, fn func(line string)) {
+	inFence := false
+	for _, line := range strings.Split(string(StripFrontMatter(content)), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inFence = !inFence
+			continue
+		}
+		if !inFence {
+			fn(inlineCodePattern.ReplaceAllString(line, ""))
+		}
+	}
+}
+
+// markdownLinkTarget returns the note a Markdown link destination points to,
+// or "" for URLs and links within the same note
+func markdownLinkTarget(dest string) string {
+	dest = strings.TrimSuffix(strings.TrimPrefix(dest, "<"), ">")
+	if u, err := url.Parse(dest); err != nil || u.Scheme != "" || u.Host != "" {
+		return ""
+	}
+	dest, _, _ = strings.Cut(dest, "#")
+	if unescaped, err := url.PathUnescape(dest); err == nil {
+		dest = unescaped
+	}
+	return dest
+}
+
+// FrontMatter is what vector-notes reads from a note's YAML front matter
+type FrontMatter struct {
+	Aliases []string
+	Tags    []string // As written, possibly with a leading #
+}
+
+// ParseFrontMatter reads the front matter at the start of content. Notes
+// without front matter, or with front matter that isn't valid YAML, have
+// none.
+func ParseFrontMatter(content []byte) FrontMatter {
+	var raw struct {
+		Aliases interface{} `yaml:"aliases"`
+		Alias   interface{} `yaml:"alias"`
+		Tags    interface{} `yaml:"tags"`
+		Tag     interface{} `yaml:"tag"`
+	}
+	data, _ := frontMatter(content)
+	if data == nil || yaml.Unmarshal(data, &raw) != nil {
+		return FrontMatter{}
+	}
+	return FrontMatter{
+		Aliases: append(stringList(raw.Aliases), stringList(raw.Alias)...),
+		Tags:    append(stringList(raw.Tags), stringList(raw.Tag)...),
+	}
+}
+
+// frontMatter returns the YAML between the --- lines opening content and
+// the offset of the line after the closing ---, or nil
+func frontMatter(content []byte) ([]byte, int) {
+	if !bytes.HasPrefix(content, []byte("---\n")) && !bytes.HasPrefix(content, []byte("---\r\n")) {
+		return nil, 0
+	}
+	start := bytes.IndexByte(content, '\n') + 1
+	for offset := start; offset < len(content); {
+		end := bytes.IndexByte(content[offset:], '\n')
+		next := offset + end + 1
+		if end < 0 {
+			end, next = len(content)-offset, len(content)
+		}
+		if string(bytes.TrimRight(content[offset:offset+end], "\r")) == "---" {
+			return content[start:offset], next
+		}
+		offset = next
+	}
+	return nil, 0
+}
+
+// StripFrontMatter returns content without its front matter
+func StripFrontMatter(content []byte) []byte {
+	_, end := frontMatter(content)
+	return content[end:]
+}
+
+// stringList reads a front matter value that is either a list or a single,
+// possibly comma-separated, string
+func stringList(value interface{}) []string {
+	var values []string
+	switch v := value.(type) {
+	case string:
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				values = append(values, s)
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok && strings.TrimSpace(s) != "" {
+				values = append(values, strings.TrimSpace(s))
+			}
+		}
+	}
+	return values
+}
+
+// LinkResolver resolves link targets to the notes of one vault the way
+// Obsidian does: by path, relative or from the vault root, then by note
+// name, then by alias. Names and aliases match case-insensitively. It is not
+// safe for concurrent use.
+type LinkResolver struct {
+	paths   map[string]string          // Lower-cased path without .md to path
+	names   map[string]map[string]bool // Lower-cased note name to paths
+	aliases map[string]map[string]bool // Lower-cased alias to paths
+	noteAka map[string][]string        // Path to the aliases it was added with
+}
+
+func NewLinkResolver() *LinkResolver {
+	return &LinkResolver{
+		paths:   make(map[string]string),
+		names:   make(map[string]map[string]bool),
+		aliases: make(map[string]map[string]bool),
+		noteAka: make(map[string][]string),
+	}
+}
+
+// Add adds the note at the vault-relative path with its aliases, replacing
+// any aliases it was added with before
+func (r *LinkResolver) Add(notePath string, aliases []string) {
+	r.Remove(notePath)
+	r.paths[linkKey(notePath)] = notePath
+	addToSet(r.names, LinkName(notePath), notePath)
+	for _, alias := range aliases {
+		addToSet(r.aliases, strings.ToLower(alias), notePath)
+	}
+	r.noteAka[notePath] = aliases
+}
+
+// Remove removes the note at the vault-relative path
+func (r *LinkResolver) Remove(notePath string) {
+	aliases, ok := r.noteAka[notePath]
+	if !ok {
+		return
+	}
+	delete(r.paths, linkKey(notePath))
+	removeFromSet(r.names, LinkName(notePath), notePath)
+	for _, alias := range aliases {
+		removeFromSet(r.aliases, strings.ToLower(alias), notePath)
+	}
+	delete(r.noteAka, notePath)
+}
+
+// Resolve returns the path of the note target refers to when written in the
+// note at from
+func (r *LinkResolver) Resolve(from, target string) (string, bool) {
+	target = strings.TrimPrefix(target, "/")
+	if strings.Contains(target, "/") {
+		if resolved, ok := r.paths[linkKey(path.Join(path.Dir(from), target))]; ok {
+			return resolved, true
+		}
+		if resolved, ok := r.paths[linkKey(path.Clean(target))]; ok {
+			return resolved, true
+		}
+		// Obsidian also matches a trailing part of the path
+		suffix := "/" + linkKey(target)
+		var matches []string
+		for key, resolved := range r.paths {
+			if strings.HasSuffix("/"+key, suffix) {
+				matches = append(matches, resolved)
+			}
+		}
+		return closest(from, matches)
+	}
+	if resolved, ok := closest(from, setKeys(r.names[LinkName(target)])); ok {
+		return resolved, true
+	}
+	return closest(from, setKeys(r.aliases[strings.ToLower(target)]))
+}
+
+// LinkName is the lower-cased note name a link target or note path is
+// looked up by
+func LinkName(target string) string {
+	return strings.ToLower(strings.TrimSuffix(path.Base(target), ".md"))
+}
+
+// linkKey is the lower-cased path a note is looked up by, without .md
+func linkKey(notePath string) string {
+	return strings.ToLower(strings.TrimSuffix(notePath, ".md"))
+}
+
+// closest picks among the notes a link matches: one in the linking note's
+// folder, else the one with the shortest path
+func closest(from string, matches []string) (string, bool) {
+	if len(matches) == 0 {
+		return "", false
+	}
+	dir := path.Dir(from)
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if sameA, sameB := path.Dir(a) == dir, path.Dir(b) == dir; sameA != sameB {
+			return sameA
+		}
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return a < b
+	})
+	return matches[0], true
+}
+
+func addToSet(sets map[string]map[string]bool, key, value string) {
+	if sets[key] == nil {
+		sets[key] = make(map[string]bool)
+	}
+	sets[key][value] = true
+}
+
+func removeFromSet(sets map[string]map[string]bool, key, value string) {
+	delete(sets[key], value)
+	if len(sets[key]) == 0 {
+		delete(sets, key)
+	}
+}
+
+func setKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,33 @@
+package notescore
+
+import (
+	"context"
+	"log/slog"
+)
+
+type loggerKey struct{}
+
+// WithLogger returns a copy of ctx carrying logger, so everything done for
+// one file logs with that file's fields
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// Logger returns the logger carried by ctx, or the default logger
+func Logger(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// Attribute keys shared by the log lines of every module, so the same field
+// is named the same whichever binary wrote it
+const (
+	KeyErr      = "err"
+	KeyPath     = "path" // Vault-relative note, or a file on disk
+	KeySource   = "source"
+	KeyVectorID = "vector_id"
+	KeyAttempt  = "attempt"
+	KeyDuration = "duration"
+)
@@ -0,0 +1,99 @@
+package notescore
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// LogConfig configures structured logging
+type LogConfig struct {
+	Level        string `yaml:"level"`         // debug, info, warn or error
+	Format       string `yaml:"format"`        // text or json
+	Output       string `yaml:"output"`        // stderr, stdout or a file to append to
+	DebugContent bool   `yaml:"debug_content"` // Log note text unredacted
+}
+
+// Attribute keys whose values are never logged, and keys holding note
+// contents, which are only logged with log.debug_content
+var (
+	secretKeys  = map[string]bool{"api_key": true, "authorization": true, "token": true, "password": true}
+	contentKeys = map[string]bool{"content": true, "text": true, "chunk_text": true, "prompt": true}
+)
+
+const redacted = "[redacted]"
+
+// minSecretLength is the shortest secret scrubbed from messages. Real API
+// keys are much longer.
+const minSecretLength = 8
+
+// SetupLogging makes slog's default logger, which the log package also
+// writes through, follow config. Values of secrets are scrubbed from every
+// message and attribute.
+func SetupLogging(config LogConfig, secrets ...string) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(config.Level)); err != nil {
+		return fmt.Errorf("log.level (LOG_LEVEL): %w", err)
+	}
+
+	var out io.Writer
+	switch config.Output {
+	case "", "stderr":
+		out = os.Stderr
+	case "stdout":
+		out = os.Stdout
+	default:
+		file, err := os.OpenFile(config.Output, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("log.output (LOG_OUTPUT): %w", err)
+		}
+		out = file
+	}
+
+	var scrub *strings.Replacer
+	var pairs []string
+	for _, secret := range secrets {
+		// Scrubbing a short placeholder key would mangle every message
+		if len(secret) >= minSecretLength {
+			pairs = append(pairs, secret, redacted)
+		}
+	}
+	if len(pairs) > 0 {
+		scrub = strings.NewReplacer(pairs...)
+	}
+	options := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			key := strings.ToLower(a.Key)
+			switch {
+			case secretKeys[key]:
+				return slog.String(a.Key, redacted)
+			case contentKeys[key] && !config.DebugContent:
+				return slog.String(a.Key, fmt.Sprintf("[redacted %d bytes]", len(a.Value.String())))
+			}
+			if scrub != nil {
+				switch value := a.Value.Any().(type) {
+				case string:
+					return slog.String(a.Key, scrub.Replace(value))
+				case error:
+					return slog.String(a.Key, scrub.Replace(value.Error()))
+				}
+			}
+			return a
+		},
+	}
+
+	var handler slog.Handler
+	switch config.Format {
+	case "", "text":
+		handler = slog.NewTextHandler(out, options)
+	case "json":
+		handler = slog.NewJSONHandler(out, options)
+	default:
+		return fmt.Errorf("log.format (LOG_FORMAT) must be text or json, not %q", config.Format)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
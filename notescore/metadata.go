@@ -0,0 +1,208 @@
+package notescore
+
+import (
+	"fmt"
+	"slices"
+
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// SchemaVersion is the version of the metadata layout written with every
+// vector. Bump it whenever a change means readers built against the old
+// layout would misread new vectors.
+const SchemaVersion = 1
+
+// Metadata keys
+const (
+	metaSchema    = "schema"
+	metaPath      = "path"
+	metaVaultID   = "vault_id"
+	metaSource    = "source"
+	metaModified  = "modified"
+	metaChunk     = "chunk"
+	metaStartLine = "start_line"
+	metaEndLine   = "end_line"
+	metaHeading   = "heading"
+	metaFilepath  = "filepath"
+	metaLinks     = "links"
+	metaBacklinks = "backlinks"
+)
+
+// MaxMetadataLinks caps the links stored with a vector, keeping heavily
+// linked notes within the index's metadata size limit
+const MaxMetadataLinks = 100
+
+// ChunkMetadata is the metadata stored with the vector of a chunk
+type ChunkMetadata struct {
+	Schema    int    // Layout version, see SchemaVersion
+	Path      string // Vault-relative, slash-separated
+	VaultID   string
+	Source    string // Name of the source the vault is configured as
+	Modified  string
+	Chunk     int
+	StartLine int // 1-based, inclusive
+	EndLine   int // 1-based, inclusive
+	Heading   string
+	Filepath  string // Absolute path, only set on vectors from before vault IDs
+	LinkInfo
+}
+
+// LinkInfo is the place of a note in the link graph, stored with each of its
+// vectors. Vectors written before links were indexed have none.
+type LinkInfo struct {
+	Links     []string `json:"links,omitempty"`     // Vault-relative paths of the notes it links to
+	Backlinks int      `json:"backlinks,omitempty"` // Number of notes linking to it
+}
+
+// Equal reports whether i and other hold the same links and backlink count
+func (i LinkInfo) Equal(other LinkInfo) bool {
+	return slices.Equal(i.Links, other.Links) && i.Backlinks == other.Backlinks
+}
+
+// Fields are the metadata fields of i, for setting on existing vectors
+func (i LinkInfo) Fields() map[string]interface{} {
+	return map[string]interface{}{
+		metaLinks:     stringValues(i.Links),
+		metaBacklinks: i.Backlinks,
+	}
+}
+
+// NewChunkMetadata returns the metadata of chunk of the note at path
+func NewChunkMetadata(chunk Chunk, path, vaultID, source, modified string) ChunkMetadata {
+	return ChunkMetadata{
+		Schema:    SchemaVersion,
+		Path:      path,
+		VaultID:   vaultID,
+		Source:    source,
+		Modified:  modified,
+		Chunk:     chunk.Index,
+		StartLine: chunk.StartLine,
+		EndLine:   chunk.EndLine,
+		Heading:   chunk.Heading,
+	}
+}
+
+// ParseChunkMetadata reads the metadata of a vector. Vectors written before
+// the schema was versioned count as version 1, whose layout they share.
+func ParseChunkMetadata(fields map[string]interface{}) ChunkMetadata {
+	m := ChunkMetadata{
+		Schema:    metadataInt(fields, metaSchema),
+		Chunk:     metadataInt(fields, metaChunk),
+		StartLine: metadataInt(fields, metaStartLine),
+		EndLine:   metadataInt(fields, metaEndLine),
+	}
+	m.Path, _ = fields[metaPath].(string)
+	m.VaultID, _ = fields[metaVaultID].(string)
+	m.Source, _ = fields[metaSource].(string)
+	m.Modified, _ = fields[metaModified].(string)
+	m.Heading, _ = fields[metaHeading].(string)
+	m.Filepath, _ = fields[metaFilepath].(string)
+	m.Backlinks = metadataInt(fields, metaBacklinks)
+	if links, ok := fields[metaLinks].([]interface{}); ok {
+		for _, link := range links {
+			if s, ok := link.(string); ok {
+				m.Links = append(m.Links, s)
+			}
+		}
+	}
+	if _, ok := fields[metaSchema]; !ok {
+		m.Schema = 1
+	}
+	return m
+}
+
+func metadataInt(fields map[string]interface{}, key string) int {
+	if value, ok := fields[key].(float64); ok {
+		return int(value)
+	}
+	return 0
+}
+
+// Struct encodes m for the index. Empty fields of legacy vectors are left out.
+func (m ChunkMetadata) Struct() (*structpb.Struct, error) {
+	fields := map[string]interface{}{
+		metaSchema:    m.Schema,
+		metaPath:      m.Path,
+		metaVaultID:   m.VaultID,
+		metaSource:    m.Source,
+		metaModified:  m.Modified,
+		metaChunk:     m.Chunk,
+		metaStartLine: m.StartLine,
+		metaEndLine:   m.EndLine,
+		metaHeading:   m.Heading,
+	}
+	if m.Filepath != "" {
+		fields[metaFilepath] = m.Filepath
+	}
+	for key, value := range m.LinkInfo.Fields() {
+		fields[key] = value
+	}
+	return structpb.NewStruct(fields)
+}
+
+// CheckSchema returns an error if m was written in a layout newer than this
+// build understands, which means vector-sync is newer than the reader
+func (m ChunkMetadata) CheckSchema() error {
+	if m.Schema > SchemaVersion {
+		return fmt.Errorf("vectors use metadata schema %d but this build only understands up to %d, upgrade it to match vector-sync", m.Schema, SchemaVersion)
+	}
+	return nil
+}
+
+// NoteFilter restricts a query to the chunks of some notes of one vault: the
+// notes at Paths and the notes linking to one of LinkedTo, except those at
+// Exclude
+type NoteFilter struct {
+	VaultID  string // Empty matches vectors without a vault ID too
+	Paths    []string
+	LinkedTo []string
+	Exclude  []string
+}
+
+// Empty reports whether f matches no note
+func (f NoteFilter) Empty() bool {
+	return len(f.Paths) == 0 && len(f.LinkedTo) == 0
+}
+
+// Struct encodes f as an index metadata filter
+func (f NoteFilter) Struct() (*structpb.Struct, error) {
+	var either []interface{}
+	if len(f.Paths) > 0 {
+		either = append(either, map[string]interface{}{metaPath: map[string]interface{}{"$in": stringValues(f.Paths)}})
+	}
+	if len(f.LinkedTo) > 0 {
+		either = append(either, map[string]interface{}{metaLinks: map[string]interface{}{"$in": stringValues(f.LinkedTo)}})
+	}
+	all := []interface{}{map[string]interface{}{"$or": either}}
+	if f.VaultID != "" {
+		all = append(all, map[string]interface{}{metaVaultID: map[string]interface{}{"$eq": f.VaultID}})
+	}
+	if len(f.Exclude) > 0 {
+		all = append(all, map[string]interface{}{metaPath: map[string]interface{}{"$nin": stringValues(f.Exclude)}})
+	}
+	return structpb.NewStruct(map[string]interface{}{"$and": all})
+}
+
+// Match reports whether the chunk with metadata m passes f
+func (f NoteFilter) Match(m ChunkMetadata) bool {
+	if (f.VaultID != "" && m.VaultID != f.VaultID) || slices.Contains(f.Exclude, m.Path) {
+		return false
+	}
+	if slices.Contains(f.Paths, m.Path) {
+		return true
+	}
+	for _, link := range m.Links {
+		if slices.Contains(f.LinkedTo, link) {
+			return true
+		}
+	}
+	return false
+}
+
+func stringValues(values []string) []interface{} {
+	list := make([]interface{}, len(values))
+	for i, value := range values {
+		list[i] = value
+	}
+	return list
+}
@@ -0,0 +1,64 @@
+package notescore
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The embedding and index metrics are counted by every binary but are not
+// registered anywhere until RegisterMetrics is called, so only a binary that
+// serves /metrics exposes them.
+
+// RegisterMetrics registers the embedding and index metrics with reg
+func RegisterMetrics(reg prometheus.Registerer) error {
+	collectors := []prometheus.Collector{
+		embeddingDuration, embeddingErrors, embeddingRetries, embeddingTruncated,
+		indexErrors, vectorsUpserted, vectorsDeleted,
+	}
+	for _, collector := range collectors {
+		if err := reg.Register(collector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DurationBuckets suit latencies from a few milliseconds to a minute, in
+// seconds
+var DurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// ObserveSince records the seconds elapsed since start in o
+func ObserveSince(o prometheus.Observer, start time.Time) {
+	o.Observe(time.Since(start).Seconds())
+}
+
+// ErrorType classifies err for the type label of error counters
+func ErrorType(err error) string {
+	var netErr net.Error
+	var embErr *EmbeddingError
+	var dimErr *DimensionError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &netErr):
+		return "network"
+	case errors.As(err, &embErr) && embErr.StatusCode == http.StatusTooManyRequests:
+		return "rate_limited"
+	case errors.As(err, &embErr) && embErr.StatusCode >= 500:
+		return "server"
+	case errors.As(err, &embErr):
+		return "client"
+	case errors.As(err, &dimErr):
+		return "dimension"
+	}
+	return "other"
+}
@@ -0,0 +1,64 @@
+package notescore
+
+import (
+	"math"
+
+	"github.com/pinecone-io/go-pinecone/v4/pinecone"
+)
+
+// NoteEmbeddings returns the normalized mean of the chunk vectors of each
+// note, keyed by the note noteOf places a vector in. Vectors without values,
+// or that noteOf returns "" for, are left out.
+func NoteEmbeddings(vectors []*pinecone.Vector, noteOf func(*pinecone.Vector) string) map[string][]float32 {
+	embeddings := make(map[string][]float32)
+	for _, vector := range vectors {
+		if vector.Values == nil {
+			continue
+		}
+		note := noteOf(vector)
+		if note == "" {
+			continue
+		}
+		sum := embeddings[note]
+		if sum == nil {
+			sum = make([]float32, len(*vector.Values))
+			embeddings[note] = sum
+		}
+		for i, value := range *vector.Values {
+			if i < len(sum) {
+				sum[i] += value
+			}
+		}
+	}
+	for _, sum := range embeddings {
+		Normalize(sum)
+	}
+	return embeddings
+}
+
+// Normalize scales v to unit length in place. A zero vector is left as is.
+func Normalize(v []float32) {
+	var norm float64
+	for _, x := range v {
+		norm += float64(x) * float64(x)
+	}
+	if norm == 0 {
+		return
+	}
+	scale := float32(1 / math.Sqrt(norm))
+	for i := range v {
+		v[i] *= scale
+	}
+}
+
+// Dot returns the dot product of a and b, their cosine similarity when both
+// are normalized. Values past the end of b are ignored.
+func Dot(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		if i < len(b) {
+			sum += a[i] * b[i]
+		}
+	}
+	return sum
+}
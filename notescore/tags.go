@@ -0,0 +1,114 @@
+package notescore
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// tagTableFile holds the tags and aliases of the vault's notes, written by
+// vector-sync after every sync so note-gpt can look notes up by them
+const tagTableFile = ".vector-notes/tags.json"
+
+// #tag and #nested/tag, at the start of a line or after a space
+var tagPattern = regexp.MustCompile(`(?:^|\s)#([\p{L}\p{N}_/-]+)`)
+
+// ParseTags returns the tags of a note, from its front matter and inline
+// #tags outside code, normalized by NormalizeTag, in order of first
+// appearance
+func ParseTags(content []byte) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	add := func(tag string) {
+		tag = NormalizeTag(tag)
+		if tag == "" || seen[tag] || !strings.ContainsFunc(tag, func(r rune) bool { return !unicode.IsDigit(r) }) {
+			return
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+
+	for _, tag := range ParseFrontMatter(content).Tags {
+		add(tag)
+	}
+	proseLines(content, func(line string) {
+		for _, match := range tagPattern.FindAllStringSubmatch(line, -1) {
+			add(match[1])
+		}
+	})
+	return tags
+}
+
+// NormalizeTag returns tag lower-cased, as Obsidian matches tags
+// case-insensitively, without the leading # and surrounding slashes
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.Trim(strings.TrimPrefix(strings.TrimSpace(tag), "#"), "/"))
+}
+
+// TagTable is the lookup table of the tags and aliases of a vault's notes
+type TagTable struct {
+	Notes map[string]NoteTerms `json:"notes"` // By vault-relative path
+}
+
+// NoteTerms are the tags and aliases of one note
+type NoteTerms struct {
+	Tags    []string `json:"tags,omitempty"` // Normalized, see NormalizeTag
+	Aliases []string `json:"aliases,omitempty"`
+}
+
+// TagTablePath is where the tag table of the vault at root is stored
+func TagTablePath(root string) string {
+	return filepath.Join(root, tagTableFile)
+}
+
+// ReadTagTable returns the tag table of the vault at root, which is empty
+// until vector-sync has written one
+func ReadTagTable(root string) (*TagTable, error) {
+	table := &TagTable{Notes: make(map[string]NoteTerms)}
+	data, err := os.ReadFile(TagTablePath(root))
+	if os.IsNotExist(err) {
+		return table, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, table); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", TagTablePath(root), err)
+	}
+	if table.Notes == nil {
+		table.Notes = make(map[string]NoteTerms)
+	}
+	return table, nil
+}
+
+// Tags returns the number of notes with each tag
+func (t *TagTable) Tags() map[string]int {
+	counts := make(map[string]int)
+	for _, terms := range t.Notes {
+		for _, tag := range terms.Tags {
+			counts[tag]++
+		}
+	}
+	return counts
+}
+
+// Tagged returns the notes with tag or a tag nested under it, sorted
+func (t *TagTable) Tagged(tag string) []string {
+	tag = NormalizeTag(tag)
+	var paths []string
+	for path, terms := range t.Notes {
+		for _, noteTag := range terms.Tags {
+			if noteTag == tag || strings.HasPrefix(noteTag, tag+"/") {
+				paths = append(paths, path)
+				break
+			}
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
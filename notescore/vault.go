@@ -0,0 +1,134 @@
+package notescore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// vaultIDFile holds the vault's ID inside the vault itself, so the ID moves
+// with the vault and is the same on every machine that syncs it
+const vaultIDFile = ".vector-notes/vault-id"
+
+// activeIndexFile records inside the vault where its vectors are served from,
+// so note-gpt follows a reindex without any config change
+const activeIndexFile = ".vector-notes/active-index.json"
+
+// VaultIDPath is where the ID of the vault at root is stored
+func VaultIDPath(root string) string {
+	return filepath.Join(root, vaultIDFile)
+}
+
+// ReadVaultID returns the ID stored in the vault at root
+func ReadVaultID(root string) (string, error) {
+	data, err := os.ReadFile(VaultIDPath(root))
+	if err != nil {
+		return "", err
+	}
+	id := strings.TrimSpace(string(data))
+	if id == "" {
+		return "", fmt.Errorf("%s is empty", VaultIDPath(root))
+	}
+	return id, nil
+}
+
+// FileId is the vector id prefix of a note, derived from the vault ID and the
+// note's vault-relative path so it doesn't change when the vault moves
+func FileId(vaultID, relPath string) string {
+	h := sha256.Sum256([]byte(vaultID + ":" + filepath.ToSlash(relPath)))
+	return hex.EncodeToString(h[:])
+}
+
+// ChunkId is the vector id of a single chunk of a file
+func ChunkId(fileId string, index int) string {
+	return fmt.Sprintf("%s#%d", fileId, index)
+}
+
+// ActiveIndex is the index and namespace a vault's vectors live in and the
+// embedding model they were made with
+type ActiveIndex struct {
+	Host      string       `json:"host"`
+	Index     string       `json:"index"`
+	Namespace string       `json:"namespace"`
+	Embedder  string       `json:"embedder"`
+	Dimension int          `json:"dimension,omitempty"`
+	Since     time.Time    `json:"since"`
+	Previous  *ActiveIndex `json:"previous,omitempty"` // Replaced by the last reindex and not yet cleaned up
+}
+
+// SameTarget reports whether a and b are the same namespace of the same index
+func (a ActiveIndex) SameTarget(b ActiveIndex) bool {
+	return a.Host == b.Host && a.Index == b.Index && a.Namespace == b.Namespace
+}
+
+func (a ActiveIndex) String() string {
+	namespace := a.Namespace
+	if namespace == "" {
+		namespace = "(default)"
+	}
+	return fmt.Sprintf("index %s namespace %s with %s", a.Index, namespace, a.Embedder)
+}
+
+// ActiveIndexPath is where the active index of the vault at root is recorded
+func ActiveIndexPath(root string) string {
+	return filepath.Join(root, activeIndexFile)
+}
+
+// ReadActiveIndex returns the active index recorded in the vault at root, or
+// nil if none has been recorded yet
+func ReadActiveIndex(root string) (*ActiveIndex, error) {
+	data, err := os.ReadFile(ActiveIndexPath(root))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var active ActiveIndex
+	if err := json.Unmarshal(data, &active); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", ActiveIndexPath(root), err)
+	}
+	return &active, nil
+}
+
+// WriteFileAtomic replaces path with data so that readers, such as a daemon
+// loading its state or the editor a vault is open in, see either the old or
+// the new content, never a partial write. The data and the rename are synced
+// before it returns.
+func WriteFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// Persist the rename itself. Not every platform can sync a directory.
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
@@ -0,0 +1,571 @@
+package notescore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pinecone-io/go-pinecone/v4/pinecone"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+var (
+	indexErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "notescore_index_errors_total",
+		Help: "Index requests that failed, by operation and error type.",
+	}, []string{"op", "type"})
+	vectorsUpserted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "notescore_vectors_upserted_total",
+		Help: "Vectors written to the index.",
+	}, []string{"source"})
+	vectorsDeleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "notescore_vectors_deleted_total",
+		Help: "Vectors deleted from the index.",
+	}, []string{"source"})
+)
+
+// countIndexError counts err, if any, under op and returns it
+func countIndexError(op string, err error) error {
+	if err != nil {
+		indexErrors.WithLabelValues(op, ErrorType(err)).Inc()
+	}
+	return err
+}
+
+// Vector is a connection to a Pinecone index. vector-sync writes through
+// copies made by ForSource; note-gpt queries through WithNamespaces.
+type Vector struct {
+	db         *pinecone.IndexConnection
+	embedder   *Embedding
+	indexName  string
+	source     string
+	vaultID    string
+	namespaces []string // Searched by Query, none for the default namespace
+	dimension  *indexDimension
+}
+
+// indexDimension is the dimension of an index, looked up on the first upsert
+// and shared by every copy of a Vector
+type indexDimension struct {
+	mu    sync.Mutex
+	value int
+}
+
+func NewVector(apiKey, host, indexName string, embedder *Embedding) (*Vector, error) {
+	client, err := pinecone.NewClient(pinecone.NewClientParams{
+		ApiKey: apiKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	index, err := client.Index(pinecone.NewIndexConnParams{
+		Host: host,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Vector{db: index, embedder: embedder, indexName: indexName, dimension: &indexDimension{}}, nil
+}
+
+// ForSource returns a copy of v that writes into namespace and tags every
+// vector with the source name and vault ID. The underlying connection is
+// shared.
+func (v *Vector) ForSource(source, vaultID, namespace string) *Vector {
+	return &Vector{
+		db:        v.db.WithNamespace(namespace),
+		embedder:  v.embedder,
+		indexName: v.indexName,
+		source:    source,
+		vaultID:   vaultID,
+		dimension: v.dimension,
+	}
+}
+
+// WithNamespaces returns a copy of v whose queries search all the given
+// namespaces. No namespaces means the index's default namespace.
+func (v *Vector) WithNamespaces(namespaces []string) *Vector {
+	return &Vector{
+		db:         v.db,
+		embedder:   v.embedder,
+		indexName:  v.indexName,
+		namespaces: namespaces,
+		dimension:  v.dimension,
+	}
+}
+
+// Close closes the connection to the index, which copies made by ForSource
+// share, and the embedder's idle connections
+func (v *Vector) Close() error {
+	v.embedder.Close()
+	return v.db.Close()
+}
+
+// Source is the name of the source v writes for
+func (v *Vector) Source() string {
+	return v.source
+}
+
+// Ping checks the index is reachable and returns its dimension and vector count
+func (v *Vector) Ping(ctx context.Context) (uint32, uint32, error) {
+	stats, err := v.db.DescribeIndexStats(ctx)
+	if err := countIndexError("describe", err); err != nil {
+		return 0, 0, fmt.Errorf("index %s: %w", v.indexName, err)
+	}
+	var dimension uint32
+	if stats.Dimension != nil {
+		dimension = *stats.Dimension
+	}
+	return dimension, stats.TotalVectorCount, nil
+}
+
+// SetDimension records the dimension of the index when it is already known,
+// saving the lookup on the first upsert
+func (v *Vector) SetDimension(dimension int) {
+	v.dimension.mu.Lock()
+	v.dimension.value = dimension
+	v.dimension.mu.Unlock()
+}
+
+// checkDimension returns a DimensionError if vectors of length got don't fit
+// the index
+func (v *Vector) checkDimension(ctx context.Context, got int) error {
+	v.dimension.mu.Lock()
+	defer v.dimension.mu.Unlock()
+	if v.dimension.value == 0 {
+		dimension, _, err := v.Ping(ctx)
+		if err != nil {
+			return err
+		}
+		v.dimension.value = int(dimension)
+	}
+	if want := v.dimension.value; want != 0 && got != want {
+		return &DimensionError{Model: v.embedder.Model(), Got: got, Want: want}
+	}
+	return nil
+}
+
+func (v *Vector) vectorizeText(ctx context.Context, text []byte, vectorizedText *[]float32) error {
+	var err error
+	*vectorizedText, err = v.embedder.Vectorize(ctx, string(text))
+	if err != nil {
+		return err
+	}
+	return v.checkDimension(ctx, len(*vectorizedText))
+}
+
+// UpsertChunks embeds and upserts every chunk of a file under ids derived
+// from fileId, then deletes any vectors left over from a previous version of
+// the file that had more chunks. previous are the ids recorded for that
+// version; when nil they are listed from the index instead. filepath is
+// relative to the vault root, and links is stored with every chunk. Returns
+// the ids of the upserted vectors.
+func (v *Vector) UpsertChunks(ctx context.Context, fileId string, chunks []Chunk, previous []string, filepath string, lastmodified string, links LinkInfo) ([]string, error) {
+	logger := Logger(ctx)
+	logger.Debug("Embedding chunks", "chunks", len(chunks))
+	records := make([]*pinecone.Vector, 0, len(chunks))
+	keep := make(map[string]bool, len(chunks))
+	ids := make([]string, 0, len(chunks))
+	for _, chunk := range chunks {
+		text, truncated := v.embedder.Input().Document(filepath, chunk)
+		if truncated {
+			embeddingTruncated.Inc()
+			logger.Debug("Truncated chunk to the model's input limit", "chunk", chunk.Index, "max_tokens", v.embedder.Input().MaxTokens)
+		}
+		var vectorizedText []float32
+		start := time.Now()
+		err := v.vectorizeText(ctx, []byte(text), &vectorizedText)
+		if err != nil {
+			return nil, err
+		}
+		logger.Debug("Embedded chunk", KeyVectorID, ChunkId(fileId, chunk.Index), KeyDuration, time.Since(start), "text", text)
+		metadata, err := v.chunkMetadata(chunk, filepath, lastmodified, links)
+		if err != nil {
+			return nil, err
+		}
+		id := ChunkId(fileId, chunk.Index)
+		keep[id] = true
+		ids = append(ids, id)
+		records = append(records, &pinecone.Vector{
+			Id:       id,
+			Values:   &vectorizedText,
+			Metadata: metadata,
+		})
+	}
+
+	if len(records) > 0 {
+		if _, err := v.db.UpsertVectors(ctx, records); err != nil {
+			return nil, countIndexError("upsert", err)
+		}
+		vectorsUpserted.WithLabelValues(v.source).Add(float64(len(records)))
+	}
+
+	stale := previous
+	if stale == nil {
+		var err error
+		stale, err = v.ListIds(ctx, fileId)
+		if err != nil {
+			logger.Warn("Could not list old vectors, stale chunks may remain", KeyErr, err)
+			return ids, nil
+		}
+	}
+	var toDelete []string
+	for _, id := range stale {
+		if !keep[id] {
+			toDelete = append(toDelete, id)
+		}
+	}
+	if err := v.DeleteIds(ctx, toDelete); err != nil {
+		return nil, err
+	}
+	logger.Debug("Upserted vectors", "vectors", len(records), "deleted", len(toDelete))
+	return ids, nil
+}
+
+// chunkMetadata is the metadata stored with the vector of a chunk
+func (v *Vector) chunkMetadata(chunk Chunk, filepath string, lastmodified string, links LinkInfo) (*structpb.Struct, error) {
+	metadata := NewChunkMetadata(chunk, filepath, v.vaultID, v.source, lastmodified)
+	metadata.LinkInfo = links
+	return metadata.Struct()
+}
+
+// RecordSize estimates the bytes the vector of a chunk takes in an upsert
+// request, for vectors of the given dimension. It builds the metadata
+// UpsertChunks would write but embeds nothing.
+func (v *Vector) RecordSize(fileId string, chunk Chunk, filepath string, lastmodified string, links LinkInfo, dimension int) (int, error) {
+	metadata, err := v.chunkMetadata(chunk, filepath, lastmodified, links)
+	if err != nil {
+		return 0, err
+	}
+	return len(ChunkId(fileId, chunk.Index)) + 4*dimension + proto.Size(metadata), nil
+}
+
+// DeleteIds deletes the vectors with the given ids
+func (v *Vector) DeleteIds(ctx context.Context, ids []string) error {
+	const batchSize = 1000
+	for start := 0; start < len(ids); start += batchSize {
+		end := start + batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		if err := v.db.DeleteVectorsById(ctx, ids[start:end]); err != nil {
+			return countIndexError("delete", err)
+		}
+		vectorsDeleted.WithLabelValues(v.source).Add(float64(end - start))
+	}
+	return nil
+}
+
+// UpdateLinks sets the link metadata of the vectors with the given ids,
+// leaving their values and other metadata as they are
+func (v *Vector) UpdateLinks(ctx context.Context, ids []string, links LinkInfo) error {
+	metadata, err := structpb.NewStruct(links.Fields())
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		err := v.db.UpdateVector(ctx, &pinecone.UpdateVectorRequest{Id: id, Metadata: metadata})
+		if err != nil {
+			return countIndexError("update", err)
+		}
+	}
+	return nil
+}
+
+// DeleteAll deletes every vector in v's namespace, other vaults' included.
+// Only use it on a namespace known to hold a single vault.
+func (v *Vector) DeleteAll(ctx context.Context) error {
+	return countIndexError("delete", v.db.DeleteAllVectorsInNamespace(ctx))
+}
+
+// EmbeddingDimension returns the dimension of the vectors v's embedder makes
+func (v *Vector) EmbeddingDimension(ctx context.Context) (int, error) {
+	values, err := v.embedder.Vectorize(ctx, "ping")
+	if err != nil {
+		return 0, err
+	}
+	return len(values), nil
+}
+
+// EmbedderVersion identifies the embedding model and input settings vectors
+// are written with
+func (v *Vector) EmbedderVersion() string {
+	return v.embedder.Version()
+}
+
+// Input is how v's embedder prepares text
+func (v *Vector) Input() Input {
+	return v.embedder.Input()
+}
+
+// ListIds returns the ids of all vectors whose id starts with prefix, or of
+// every vector in the namespace when prefix is empty
+func (v *Vector) ListIds(ctx context.Context, prefix string) ([]string, error) {
+	return listIds(ctx, v.db, prefix)
+}
+
+func listIds(ctx context.Context, db *pinecone.IndexConnection, prefix string) ([]string, error) {
+	var ids []string
+	var token *string
+	var prefixFilter *string
+	if prefix != "" {
+		prefixFilter = &prefix
+	}
+	limit := uint32(100)
+	for {
+		resp, err := db.ListVectors(ctx, &pinecone.ListVectorsRequest{
+			Prefix:          prefixFilter,
+			Limit:           &limit,
+			PaginationToken: token,
+		})
+		if err != nil {
+			return nil, countIndexError("list", err)
+		}
+		for _, id := range resp.VectorIds {
+			if id != nil {
+				ids = append(ids, *id)
+			}
+		}
+		if resp.NextPaginationToken == nil || *resp.NextPaginationToken == "" {
+			return ids, nil
+		}
+		token = resp.NextPaginationToken
+	}
+}
+
+// FetchMetadata returns the metadata of the vectors with the given ids. Ids
+// that don't exist are missing from the result.
+func (v *Vector) FetchMetadata(ctx context.Context, ids []string) (map[string]map[string]interface{}, error) {
+	vectors, err := fetchVectors(ctx, v.db, ids)
+	if err != nil {
+		return nil, err
+	}
+	metadata := make(map[string]map[string]interface{}, len(vectors))
+	for id, vector := range vectors {
+		if vector.Metadata != nil {
+			metadata[id] = vector.Metadata.AsMap()
+		} else {
+			metadata[id] = map[string]interface{}{}
+		}
+	}
+	return metadata, nil
+}
+
+// FetchVectors returns the vectors with the given ids, values and metadata
+// included. Ids that don't exist are missing from the result.
+func (v *Vector) FetchVectors(ctx context.Context, ids []string) (map[string]*pinecone.Vector, error) {
+	return fetchVectors(ctx, v.db, ids)
+}
+
+// FetchFile returns the stored vectors of the file with the given id in
+// namespace, values and metadata included, in chunk order
+func (v *Vector) FetchFile(ctx context.Context, namespace, fileId string) ([]*pinecone.Vector, error) {
+	db := v.db.WithNamespace(namespace)
+	ids, err := listIds(ctx, db, fileId)
+	if err != nil {
+		return nil, err
+	}
+	fetched, err := fetchVectors(ctx, db, ids)
+	if err != nil {
+		return nil, err
+	}
+	vectors := make([]*pinecone.Vector, 0, len(fetched))
+	for _, vector := range fetched {
+		vectors = append(vectors, vector)
+	}
+	SortChunks(vectors)
+	return vectors, nil
+}
+
+// FetchNamespace returns every stored vector in namespace, values and
+// metadata included, sorted by id
+func (v *Vector) FetchNamespace(ctx context.Context, namespace string) ([]*pinecone.Vector, error) {
+	db := v.db.WithNamespace(namespace)
+	ids, err := listIds(ctx, db, "")
+	if err != nil {
+		return nil, err
+	}
+	fetched, err := fetchVectors(ctx, db, ids)
+	if err != nil {
+		return nil, err
+	}
+	vectors := make([]*pinecone.Vector, 0, len(fetched))
+	for _, vector := range fetched {
+		vectors = append(vectors, vector)
+	}
+	sort.Slice(vectors, func(i, j int) bool {
+		return vectors[i].Id < vectors[j].Id
+	})
+	return vectors, nil
+}
+
+// SortChunks sorts the vectors of one file by chunk index
+func SortChunks(vectors []*pinecone.Vector) {
+	index := func(vector *pinecone.Vector) int {
+		if vector.Metadata == nil {
+			return 0
+		}
+		return ParseChunkMetadata(vector.Metadata.AsMap()).Chunk
+	}
+	sort.SliceStable(vectors, func(i, j int) bool {
+		return index(vectors[i]) < index(vectors[j])
+	})
+}
+
+// fetchVectors fetches the vectors with the given ids in batches. Ids that
+// don't exist are missing from the result.
+func fetchVectors(ctx context.Context, db *pinecone.IndexConnection, ids []string) (map[string]*pinecone.Vector, error) {
+	const batchSize = 100
+	vectors := make(map[string]*pinecone.Vector, len(ids))
+	for start := 0; start < len(ids); start += batchSize {
+		end := start + batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		resp, err := db.FetchVectors(ctx, ids[start:end])
+		if err != nil {
+			return nil, countIndexError("fetch", err)
+		}
+		for id, vector := range resp.Vectors {
+			vectors[id] = vector
+		}
+	}
+	return vectors, nil
+}
+
+// MoveFile re-keys every vector of a file from oldFileId to newFileId without
+// re-embedding it. The vectors are copied with their values, their metadata
+// is updated to the vault-relative path, and the old vectors are deleted.
+func (v *Vector) MoveFile(ctx context.Context, oldFileId, newFileId, relPath string) (int, error) {
+	ids, err := v.ListIds(ctx, oldFileId)
+	if err != nil {
+		return 0, err
+	}
+
+	const batchSize = 100
+	moved := 0
+	for start := 0; start < len(ids); start += batchSize {
+		end := start + batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		batch := ids[start:end]
+		resp, err := v.db.FetchVectors(ctx, batch)
+		if err != nil {
+			return moved, countIndexError("fetch", err)
+		}
+
+		records := make([]*pinecone.Vector, 0, len(resp.Vectors))
+		for id, vector := range resp.Vectors {
+			var meta ChunkMetadata
+			if vector.Metadata != nil {
+				meta = ParseChunkMetadata(vector.Metadata.AsMap())
+			}
+			meta.Schema = SchemaVersion
+			meta.Path = relPath
+			meta.VaultID = v.vaultID
+			meta.Source = v.source
+			meta.Filepath = ""
+			metadata, err := meta.Struct()
+			if err != nil {
+				return moved, err
+			}
+			records = append(records, &pinecone.Vector{
+				Id:       newFileId + strings.TrimPrefix(id, oldFileId),
+				Values:   vector.Values,
+				Metadata: metadata,
+			})
+		}
+		if len(records) > 0 {
+			if _, err := v.db.UpsertVectors(ctx, records); err != nil {
+				return moved, countIndexError("upsert", err)
+			}
+		}
+		if err := v.db.DeleteVectorsById(ctx, batch); err != nil {
+			return moved, countIndexError("delete", err)
+		}
+		moved += len(records)
+	}
+	return moved, nil
+}
+
+// Query embeds queryText and returns the topK closest vectors across v's
+// namespaces
+func (v *Vector) Query(ctx context.Context, queryText []byte, topK int) ([]*pinecone.ScoredVector, error) {
+	values, err := v.EmbedQuery(ctx, queryText)
+	if err != nil {
+		return nil, err
+	}
+	return v.search(ctx, values, topK, nil)
+}
+
+// EmbedQuery embeds queryText as a search query, so that one query can be
+// searched several times with QueryValues and QueryValuesNotes
+func (v *Vector) EmbedQuery(ctx context.Context, queryText []byte) ([]float32, error) {
+	start := time.Now()
+	text := v.embedder.Input().Query(string(queryText))
+	values, err := v.embedder.Vectorize(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+	Logger(ctx).Debug("Embedded query", "text", text, "dimension", len(values), KeyDuration, time.Since(start))
+	return values, nil
+}
+
+// QueryValues returns the topK vectors closest to values across v's
+// namespaces, such as those of another note's chunks
+func (v *Vector) QueryValues(ctx context.Context, values []float32, topK int) ([]*pinecone.ScoredVector, error) {
+	return v.search(ctx, values, topK, nil)
+}
+
+// QueryValuesNotes is QueryValues restricted to the chunks of the notes
+// filter matches
+func (v *Vector) QueryValuesNotes(ctx context.Context, values []float32, topK int, filter NoteFilter) ([]*pinecone.ScoredVector, error) {
+	if filter.Empty() {
+		return nil, nil
+	}
+	metadataFilter, err := filter.Struct()
+	if err != nil {
+		return nil, err
+	}
+	return v.search(ctx, values, topK, metadataFilter)
+}
+
+func (v *Vector) search(ctx context.Context, values []float32, topK int, filter *pinecone.MetadataFilter) ([]*pinecone.ScoredVector, error) {
+	query := pinecone.QueryByVectorValuesRequest{
+		TopK:            uint32(topK),
+		IncludeValues:   false,
+		IncludeMetadata: true,
+		Vector:          values,
+		MetadataFilter:  filter,
+	}
+	if len(v.namespaces) == 0 {
+		response, err := v.db.QueryByVectorValues(ctx, &query)
+		if err != nil {
+			return nil, countIndexError("query", err)
+		}
+		return response.Matches, nil
+	}
+
+	// Scores from one index are comparable across namespaces, so merge the
+	// per-namespace results and keep the overall top K
+	var matches []*pinecone.ScoredVector
+	for _, namespace := range v.namespaces {
+		response, err := v.db.WithNamespace(namespace).QueryByVectorValues(ctx, &query)
+		if err != nil {
+			return nil, fmt.Errorf("namespace %q: %w", namespace, countIndexError("query", err))
+		}
+		matches = append(matches, response.Matches...)
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	if len(matches) > topK {
+		matches = matches[:topK]
+	}
+	return matches, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"notescore"
+	"os"
+	"vector-sync/internal"
+)
+
+// runConfig implements `vector-sync config check`, which validates the
+// configuration and then tests connectivity to every configured service
+func runConfig(args []string) int {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Println("usage: vector-sync config check [flags]")
+		return exitUsage
+	}
+
+	fs := flag.NewFlagSet("config check", flag.ContinueOnError)
+	var flags internal.Flags
+	flags.Register(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		return exitUsage
+	}
+
+	config, err := internal.LoadConfig(flags)
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		return exitFailed
+	}
+
+	checks := notescore.SourceChecks(config)
+	checks = append(checks, notescore.Check{Name: "state dir", Probe: func(ctx context.Context) (string, error) {
+		if err := os.MkdirAll(config.Sync.StateDir, 0755); err != nil {
+			return "", err
+		}
+		probe, err := os.CreateTemp(config.Sync.StateDir, ".check-*")
+		if err != nil {
+			return "", err
+		}
+		probe.Close()
+		os.Remove(probe.Name())
+		return config.Sync.StateDir, nil
+	}})
+	checks = append(checks, notescore.IndexChecks(config)...)
+	if !notescore.RunChecks(config, checks...) {
+		return exitFailed
+	}
+	return exitOK
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"notescore"
+	"os"
+	"vector-sync/internal"
+)
+
+// runDupes implements `vector-sync dupes`, which reports notes with the same
+// content and clusters of near-duplicate notes. It only reads, so it is safe
+// while the daemon runs.
+func runDupes(args []string) int {
+	fs := flag.NewFlagSet("dupes", flag.ContinueOnError)
+	only := fs.String("source", "", "only check this source")
+	threshold := fs.Float64("threshold", 0.95, "similarity from which notes count as near duplicates")
+	format := fs.String("format", "text", "report format: text or json")
+	var flags internal.Flags
+	flags.Register(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vector-sync dupes [-threshold n] [-format text|json] [-source name] [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() > 0 || *threshold <= 0 || *threshold > 1 || (*format != "text" && *format != "json") {
+		fs.Usage()
+		return exitUsage
+	}
+
+	config, err := internal.LoadConfig(flags)
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		return exitFailed
+	}
+	sources, ok := selectSources(config, *only)
+	if !ok {
+		return exitUsage
+	}
+
+	ctx := context.Background()
+	reports := []*internal.DupesReport{}
+	code := exitOK
+	for _, source := range sources {
+		report, err := findDupes(ctx, source, config, float32(*threshold))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error checking source %s: %v\n", source.Name, err)
+			code = exitFailed
+			continue
+		}
+		if report != nil {
+			reports = append(reports, report)
+		}
+	}
+
+	if *format == "json" {
+		data, err := json.MarshalIndent(reports, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding report: %v\n", err)
+			return exitFailed
+		}
+		fmt.Println(string(data))
+		return code
+	}
+	for i, report := range reports {
+		if i > 0 {
+			fmt.Println()
+		}
+		printDupes(report)
+	}
+	return code
+}
+
+// findDupes reports the duplicates of one source, or nil if it was never
+// synced
+func findDupes(ctx context.Context, source internal.SourceConfig, config *internal.Config, threshold float32) (*internal.DupesReport, error) {
+	state, err := readSourceState(source, config)
+	if err != nil {
+		return nil, err
+	}
+	if state.vaultID == "" {
+		fmt.Fprintf(os.Stderr, "%s: never synced, nothing to check\n", source.Name)
+		return nil, nil
+	}
+	target := configuredIndex(config, source)
+	if active, err := notescore.ReadActiveIndex(source.Path); err != nil {
+		return nil, err
+	} else if active != nil {
+		target = *active
+	}
+	sourceDb, err := connectIndex(config, target, source, state.vaultID)
+	if err != nil {
+		return nil, err
+	}
+	return internal.FindDuplicates(ctx, state.records, state.vaultID, sourceDb, threshold)
+}
+
+func printDupes(report *internal.DupesReport) {
+	fmt.Printf("%s: %d notes, %d exact duplicate groups, %d near-duplicate clusters\n",
+		report.Source, report.Notes, len(report.Exact), len(report.Near))
+	for _, group := range report.Exact {
+		fmt.Printf("  exact (%d notes, hash %.12s)\n", len(group.Paths), group.Hash)
+		for _, path := range group.Paths {
+			fmt.Printf("    %s\n", path)
+		}
+	}
+	for _, group := range report.Near {
+		fmt.Printf("  near (%d notes)\n", len(group.Paths))
+		for _, pair := range group.Pairs {
+			fmt.Printf("    %.3f  %s  ~  %s\n", pair.Similarity, pair.A, pair.B)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"notescore"
+	"os"
+	"path/filepath"
+	"strings"
+	"vector-sync/internal"
+)
+
+// runForget implements `vector-sync forget`, which deletes the vectors and
+// state records of files or directories. It needs the vault lock, so it
+// fails with exitLocked while a daemon syncs the source.
+func runForget(args []string) int {
+	fs := flag.NewFlagSet("forget", flag.ContinueOnError)
+	only := fs.String("source", "", "source the paths belong to (default: the source containing them)")
+	var flags internal.Flags
+	flags.Register(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vector-sync forget [-source name] [flags] <path>...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	config, err := internal.LoadConfig(flags)
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		return exitFailed
+	}
+	sources, ok := selectSources(config, *only)
+	if !ok {
+		return exitUsage
+	}
+
+	// Group the paths by source so each vault is locked once
+	paths := make(map[string][]string)
+	var order []internal.SourceConfig
+	for _, arg := range fs.Args() {
+		source, rel, err := resolveForgetPath(sources, arg)
+		if err != nil {
+			fmt.Println(err)
+			return exitUsage
+		}
+		if _, seen := paths[source.Name]; !seen {
+			order = append(order, source)
+		}
+		paths[source.Name] = append(paths[source.Name], rel)
+	}
+
+	code := exitOK
+	for _, source := range order {
+		if err := forgetPaths(context.Background(), source, config, paths[source.Name]); err != nil {
+			fmt.Printf("Error forgetting in source %s: %v\n", source.Name, err)
+			if code == exitOK || exitCode(err) == exitLocked {
+				code = exitCode(err)
+			}
+		}
+	}
+	return code
+}
+
+// resolveForgetPath maps a path given on the command line to its source and a
+// vault-relative path. Absolute paths pick the source containing them;
+// relative paths are relative to the vault and need a single source.
+func resolveForgetPath(sources []internal.SourceConfig, arg string) (internal.SourceConfig, string, error) {
+	if filepath.IsAbs(arg) {
+		for _, source := range sources {
+			rel, err := filepath.Rel(source.Path, arg)
+			if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return source, filepath.ToSlash(rel), nil
+			}
+		}
+		return internal.SourceConfig{}, "", fmt.Errorf("%s is not inside a source", arg)
+	}
+	if len(sources) > 1 {
+		return internal.SourceConfig{}, "", fmt.Errorf("%s is relative and there are several sources, pass -source or an absolute path", arg)
+	}
+	rel := filepath.ToSlash(filepath.Clean(arg))
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return internal.SourceConfig{}, "", fmt.Errorf("%s is not inside the vault", arg)
+	}
+	return sources[0], rel, nil
+}
+
+func forgetPaths(ctx context.Context, source internal.SourceConfig, config *internal.Config, paths []string) error {
+	vaultID, err := notescore.ReadVaultID(source.Path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("never synced, nothing to forget")
+	}
+	if err != nil {
+		return err
+	}
+	lock, err := internal.LockVault(source.Path)
+	if err != nil {
+		return fmt.Errorf("%w; stop vector-sync first", err)
+	}
+	defer lock.Unlock()
+
+	active, err := internal.EnsureActiveIndex(source.Path, configuredIndex(config, source))
+	if err != nil {
+		return err
+	}
+	vectorDb, err := connectIndex(config, *active, source, vaultID)
+	if err != nil {
+		return err
+	}
+	state, err := internal.OpenStateStore(internal.StateDir(config.Sync.StateDir, vaultID))
+	if err != nil {
+		return err
+	}
+	defer state.Close()
+
+	for _, path := range paths {
+		forgotten, err := internal.ForgetPath(ctx, vectorDb, state, vaultID, path)
+		for _, p := range forgotten {
+			fmt.Printf("forgot %s\n", p)
+		}
+		if err != nil {
+			return err
+		}
+		if len(forgotten) == 0 {
+			return fmt.Errorf("nothing is synced at %s", path)
+		}
+		if _, err := os.Stat(filepath.Join(source.Path, filepath.FromSlash(path))); err == nil {
+			fmt.Printf("%s still exists, so the next sync embeds it again unless it is ignored\n", path)
+		}
+	}
+	return nil
+}
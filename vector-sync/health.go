@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"notescore"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// healthServer serves /healthz, /readyz and /metrics for the daemon. Its
+// methods do nothing on a nil server, which is what the daemon runs with when
+// metrics.listen is not set.
+type healthServer struct {
+	server *http.Server
+	ready  atomic.Bool
+}
+
+// startHealthServer listens on addr and serves in the background. The daemon
+// is reported as not ready until SetReady is called.
+func startHealthServer(addr string) (*healthServer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error listening for metrics: %w", err)
+	}
+	// Embedding and index metrics are kept by notescore and only exposed here
+	if err := notescore.RegisterMetrics(prometheus.DefaultRegisterer); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("error registering metrics: %w", err)
+	}
+	h := &healthServer{}
+	mux := http.NewServeMux()
+	// Alive as long as the process answers
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	// Ready once every source is being watched, until shutdown starts
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !h.ready.Load() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ready")
+	})
+	mux.Handle("/metrics", promhttp.Handler())
+	h.server = &http.Server{Handler: mux}
+
+	go func() {
+		if err := h.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Metrics server failed", notescore.KeyErr, err)
+		}
+	}()
+	slog.Info("Serving /healthz, /readyz and /metrics", "addr", listener.Addr().String())
+	return h, nil
+}
+
+func (h *healthServer) SetReady(ready bool) {
+	if h != nil {
+		h.ready.Store(ready)
+	}
+}
+
+// Shutdown stops the server once in-flight requests are answered or ctx is
+// done
+func (h *healthServer) Shutdown(ctx context.Context) {
+	if h == nil {
+		return
+	}
+	if err := h.server.Shutdown(ctx); err != nil {
+		slog.Error("Error stopping metrics server", notescore.KeyErr, err)
+	}
+}
@@ -0,0 +1,39 @@
+package internal
+
+import (
+	"encoding/json"
+	"notescore"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The active index is read by note-gpt too, so its type lives in notescore.
+// Only vector-sync writes it.
+
+// WriteActiveIndex atomically replaces the active index of the vault at root
+func WriteActiveIndex(root string, active *notescore.ActiveIndex) error {
+	data, err := json.MarshalIndent(active, "", "    ")
+	if err != nil {
+		return err
+	}
+	path := notescore.ActiveIndexPath(root)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return notescore.WriteFileAtomic(path, data)
+}
+
+// EnsureActiveIndex returns the active index of the vault at root. The first
+// time, it records configured, the target the vault has been synced to so far.
+func EnsureActiveIndex(root string, configured notescore.ActiveIndex) (*notescore.ActiveIndex, error) {
+	active, err := notescore.ReadActiveIndex(root)
+	if err != nil || active != nil {
+		return active, err
+	}
+	configured.Since = time.Now().UTC()
+	if err := WriteActiveIndex(root, &configured); err != nil {
+		return nil, err
+	}
+	return &configured, nil
+}
@@ -2,61 +2,35 @@ package internal
 
 import (
 	"fmt"
-	"os"
-
-	"github.com/joho/godotenv"
+	"notescore"
 )
 
-type Config struct {
-	// Required API keys
-	PineconeAPIKey string
-	NotesDir       string
-	PineconeHost   string
-	EmbeddingUrl   string
-}
-
-// LoadConfig loads configuration from .env file and environment variables
-func LoadConfig() (*Config, error) {
-	// Try to load .env file (optional - don't fail if it doesn't exist)
-	if err := godotenv.Load(); err != nil {
-		fmt.Printf("Info: .env file not found, using environment variables only\n")
-	}
-
-	config := &Config{
-		PineconeAPIKey: getEnvRequired("PINECONE_API_KEY"),
-		PineconeHost:   getEnvRequired("PINECONE_HOST"),
-		NotesDir:       getEnvRequired("NOTES_DIR"),
-		EmbeddingUrl:   os.Getenv("EMBEDDING_URL"),
-	}
+// The config file is shared with note-gpt, so its types live in notescore
+type (
+	Config       = notescore.Config
+	Flags        = notescore.Flags
+	SourceConfig = notescore.SourceConfig
+)
 
-	if err := config.validate(); err != nil {
-		return nil, fmt.Errorf("config validation failed: %w", err)
-	}
+// DefaultSourceName names the source built from vault.path
+const DefaultSourceName = notescore.DefaultSourceName
 
-	return config, nil
+// LoadConfig loads the configuration vector-sync runs with, see
+// notescore.LoadConfig
+func LoadConfig(flags Flags) (*Config, error) {
+	return notescore.LoadConfig(flags, notescore.IndexServices, validateSync)
 }
 
-func (c *Config) validate() error {
-	if c.PineconeAPIKey == "" {
-		return fmt.Errorf("PINECONE_API_KEY is required")
-	}
-	if c.PineconeHost == "" {
-		return fmt.Errorf("PINECONE_HOST is required")
+func validateSync(c *Config) []error {
+	var errs []error
+	if c.Sync.Interval <= 0 {
+		errs = append(errs, fmt.Errorf("sync.interval (SYNC_INTERVAL) must be positive"))
 	}
-	if c.NotesDir == "" {
-		return fmt.Errorf("NOTES_DIR is required")
+	if c.Sync.ShutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("sync.shutdown_timeout (SHUTDOWN_TIMEOUT) must be positive"))
 	}
-	if c.EmbeddingUrl == "" {
-		c.EmbeddingUrl = "http://localhost:8000/embed" // default value
-	}
-	return nil
-}
-
-// Helper function
-func getEnvRequired(key string) string {
-	value := os.Getenv(key)
-	if value == "" {
-		panic(fmt.Sprintf("Required environment variable %s is not set", key))
+	if c.Sync.StateDir == "" {
+		errs = append(errs, fmt.Errorf("sync.state_dir (STATE_DIR) must not be empty"))
 	}
-	return value
+	return errs
 }
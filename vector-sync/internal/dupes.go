@@ -0,0 +1,175 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"notescore"
+	"sort"
+
+	"github.com/pinecone-io/go-pinecone/v4/pinecone"
+)
+
+// DupesReport lists the notes of one source that duplicate each other, as of
+// the last sync
+type DupesReport struct {
+	Source    string      `json:"source"`
+	Notes     int         `json:"notes"`     // Synced notes that are not empty
+	Threshold float32     `json:"threshold"` // Similarity from which notes count as near duplicates
+	Exact     []DupeGroup `json:"exact"`
+	Near      []DupeGroup `json:"near"`
+}
+
+// DupeGroup is a set of notes that duplicate each other: notes with the same
+// content, or a cluster of notes each similar enough to another one in it
+type DupeGroup struct {
+	Paths []string   `json:"paths"`           // Vault-relative, sorted
+	Hash  string     `json:"hash,omitempty"`  // Content hash of exact duplicates
+	Pairs []DupePair `json:"pairs,omitempty"` // Similar pairs that formed a cluster, most similar first
+}
+
+// DupePair is two near-duplicate notes
+type DupePair struct {
+	A          string  `json:"a"`
+	B          string  `json:"b"`
+	Similarity float32 `json:"similarity"`
+}
+
+// FindDuplicates groups the non-empty notes in records by content hash, and
+// clusters the notes with known chunks whose embeddings are at least
+// threshold similar. A note's embedding
+// is the mean of its chunk vectors, fetched from vectorDb, so nothing is
+// embedded again. Notes with the same content are not paired as near
+// duplicates, as they are already exact ones.
+func FindDuplicates(ctx context.Context, records []FileRecord, vaultID string, vectorDb *notescore.Vector, threshold float32) (*DupesReport, error) {
+	report := &DupesReport{Source: vectorDb.Source(), Threshold: threshold, Exact: []DupeGroup{}, Near: []DupeGroup{}}
+
+	// Every record has a content hash, including those imported from legacy
+	// state, but only records written by a sync know their chunks
+	emptyHash := CalculateHash(nil)
+	byHash := make(map[string][]string)
+	var notes []FileRecord
+	for _, record := range records {
+		if record.Hash == "" || record.Hash == emptyHash {
+			continue
+		}
+		report.Notes++
+		byHash[record.Hash] = append(byHash[record.Hash], record.Path)
+		if len(record.ChunkHashes) > 0 {
+			notes = append(notes, record)
+		}
+	}
+	for hash, paths := range byHash {
+		if len(paths) > 1 {
+			sort.Strings(paths)
+			report.Exact = append(report.Exact, DupeGroup{Paths: paths, Hash: hash})
+		}
+	}
+	sortGroups(report.Exact)
+
+	embeddings, err := noteEmbeddings(ctx, notes, vaultID, vectorDb)
+	if err != nil {
+		return nil, err
+	}
+	var pairs []DupePair
+	for i := range notes {
+		for j := i + 1; j < len(notes); j++ {
+			a, b := notes[i], notes[j]
+			if a.Hash == b.Hash || embeddings[a.Path] == nil || embeddings[b.Path] == nil {
+				continue
+			}
+			if similarity := notescore.Dot(embeddings[a.Path], embeddings[b.Path]); similarity >= threshold {
+				pairs = append(pairs, DupePair{A: a.Path, B: b.Path, Similarity: similarity})
+			}
+		}
+	}
+	report.Near = clusterPairs(pairs)
+	return report, nil
+}
+
+// noteEmbeddings returns the normalized mean chunk vector of each note
+func noteEmbeddings(ctx context.Context, notes []FileRecord, vaultID string, vectorDb *notescore.Vector) (map[string][]float32, error) {
+	var ids []string
+	pathOf := make(map[string]string)
+	for _, record := range notes {
+		recorded := record.VectorIds
+		if recorded == nil {
+			var err error
+			recorded, err = vectorDb.ListIds(ctx, notescore.FileId(vaultID, record.Path))
+			if err != nil {
+				return nil, fmt.Errorf("failed to list the vectors of %s: %w", record.Path, err)
+			}
+		}
+		for _, id := range recorded {
+			ids = append(ids, id)
+			pathOf[id] = record.Path
+		}
+	}
+	vectors, err := vectorDb.FetchVectors(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch vectors: %w", err)
+	}
+
+	fetched := make([]*pinecone.Vector, 0, len(vectors))
+	for _, vector := range vectors {
+		fetched = append(fetched, vector)
+	}
+	return notescore.NoteEmbeddings(fetched, func(vector *pinecone.Vector) string {
+		return pathOf[vector.Id]
+	}), nil
+}
+
+// clusterPairs joins near-duplicate pairs sharing a note into clusters
+func clusterPairs(pairs []DupePair) []DupeGroup {
+	parent := make(map[string]string)
+	var find func(path string) string
+	find = func(path string) string {
+		if parent[path] == "" || parent[path] == path {
+			parent[path] = path
+			return path
+		}
+		root := find(parent[path])
+		parent[path] = root
+		return root
+	}
+	for _, pair := range pairs {
+		parent[find(pair.A)] = find(pair.B)
+	}
+
+	byRoot := make(map[string]*DupeGroup)
+	for _, pair := range pairs {
+		root := find(pair.A)
+		if byRoot[root] == nil {
+			byRoot[root] = &DupeGroup{}
+		}
+		byRoot[root].Pairs = append(byRoot[root].Pairs, pair)
+	}
+	groups := []DupeGroup{}
+	for _, group := range byRoot {
+		seen := make(map[string]bool)
+		for _, pair := range group.Pairs {
+			for _, path := range []string{pair.A, pair.B} {
+				if !seen[path] {
+					seen[path] = true
+					group.Paths = append(group.Paths, path)
+				}
+			}
+		}
+		sort.Strings(group.Paths)
+		sort.Slice(group.Pairs, func(i, j int) bool {
+			return group.Pairs[i].Similarity > group.Pairs[j].Similarity
+		})
+		groups = append(groups, *group)
+	}
+	sortGroups(groups)
+	return groups
+}
+
+// sortGroups puts the largest groups first, then sorts by first path
+func sortGroups(groups []DupeGroup) {
+	sort.Slice(groups, func(i, j int) bool {
+		if len(groups[i].Paths) != len(groups[j].Paths) {
+			return len(groups[i].Paths) > len(groups[j].Paths)
+		}
+		return groups[i].Paths[0] < groups[j].Paths[0]
+	})
+}
@@ -0,0 +1,51 @@
+package internal
+
+import (
+	"path"
+	"strings"
+)
+
+// IgnoreRules decides which vault-relative paths are left out of the tree.
+// A pattern ending in "/**" matches a directory and everything below it, a
+// pattern containing "/" is matched against the whole path, and any other
+// pattern is matched against each path segment, so "*.excalidraw.md" or
+// "Templates" apply at any depth.
+type IgnoreRules struct {
+	patterns []string
+}
+
+func NewIgnoreRules(patterns []string) *IgnoreRules {
+	return &IgnoreRules{patterns: patterns}
+}
+
+// Match reports whether relPath, relative to the vault root and using "/"
+// separators, is ignored
+func (r *IgnoreRules) Match(relPath string) bool {
+	if r == nil {
+		return false
+	}
+	relPath = strings.Trim(relPath, "/")
+	if relPath == "" || relPath == "." {
+		return false
+	}
+	for _, pattern := range r.patterns {
+		if dir, ok := strings.CutSuffix(pattern, "/**"); ok {
+			if relPath == dir || strings.HasPrefix(relPath, dir+"/") {
+				return true
+			}
+			continue
+		}
+		if strings.Contains(pattern, "/") {
+			if matched, _ := path.Match(strings.Trim(pattern, "/"), relPath); matched {
+				return true
+			}
+			continue
+		}
+		for _, segment := range strings.Split(relPath, "/") {
+			if matched, _ := path.Match(pattern, segment); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,389 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"notescore"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// NoteLinks is what the state records about the links of a note
+type NoteLinks struct {
+	Targets []string            `json:"targets,omitempty"` // Outgoing links as written, see notescore.ParseLinks
+	Aliases []string            `json:"aliases,omitempty"` // From the front matter
+	Tags    []string            `json:"tags"`              // See notescore.ParseTags, nil for records from before tags were tracked
+	Indexed *notescore.LinkInfo `json:"indexed,omitempty"` // What the note's vectors carry
+}
+
+// ParseNoteLinks reads the outgoing links, aliases and tags of a note
+func ParseNoteLinks(content []byte) NoteLinks {
+	return NoteLinks{
+		Targets: notescore.ParseLinks(content),
+		Aliases: notescore.ParseFrontMatter(content).Aliases,
+		Tags:    append([]string{}, notescore.ParseTags(content)...),
+	}
+}
+
+// LinkGraph is the graph of links between the synced notes of a vault. It is
+// loaded from the state and updated as notes are synced and removed. A
+// change only resolves again the links it can affect: those using the name
+// or an alias of a note that appeared or went away. LinkGraph is safe for
+// concurrent use.
+type LinkGraph struct {
+	mu       sync.Mutex
+	notes    map[string]NoteLinks // Targets, aliases and tags by path, Indexed is not kept
+	resolver *notescore.LinkResolver
+	out      map[string][]string        // Notes each note links to, sorted
+	broken   map[string][]string        // Targets of each note that resolve to no note
+	in       map[string]map[string]bool // Notes linking to each note
+	wanted   map[string]map[string]bool // Notes with a link to each notescore.LinkName
+	changed  map[string]bool            // Notes whose LinkInfo may differ from their vectors'
+	terms    bool                       // Whether any tags or aliases changed since the last TagTable
+}
+
+func NewLinkGraph() *LinkGraph {
+	return &LinkGraph{
+		notes:    make(map[string]NoteLinks),
+		resolver: notescore.NewLinkResolver(),
+		out:      make(map[string][]string),
+		broken:   make(map[string][]string),
+		in:       make(map[string]map[string]bool),
+		wanted:   make(map[string]map[string]bool),
+		changed:  make(map[string]bool),
+	}
+}
+
+// LoadLinkGraph builds the graph of the notes in records. Records from before
+// links or tags were tracked are parsed from the notes under root instead.
+// Every note starts out as changed, so the first UpdateLinkMetadata brings
+// all vectors up to date.
+func LoadLinkGraph(records []FileRecord, root string) *LinkGraph {
+	g := NewLinkGraph()
+	for _, record := range records {
+		links := NoteLinks{}
+		if record.Links != nil && record.Links.Tags != nil {
+			links = *record.Links
+		} else if content, err := os.ReadFile(filepath.Join(root, record.Path)); err == nil {
+			links = ParseNoteLinks(content)
+		}
+		g.notes[record.Path] = NoteLinks{Targets: links.Targets, Aliases: links.Aliases, Tags: links.Tags}
+		g.resolver.Add(record.Path, links.Aliases)
+		g.want(record.Path, links.Targets)
+	}
+	for path := range g.notes {
+		g.resolve(path)
+		g.changed[path] = true
+	}
+	g.terms = true
+	return g
+}
+
+// Set records the links and aliases of the note at path, adding the note if
+// it is new
+func (g *LinkGraph) Set(path string, links NoteLinks) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	old, existed := g.notes[path]
+	g.unwant(path, old.Targets)
+	g.notes[path] = NoteLinks{Targets: links.Targets, Aliases: links.Aliases, Tags: links.Tags}
+	g.want(path, links.Targets)
+	if !existed || !slices.Equal(old.Aliases, links.Aliases) || !slices.Equal(old.Tags, links.Tags) {
+		g.terms = true
+	}
+
+	affected := map[string]bool{path: true}
+	if !existed || !slices.Equal(old.Aliases, links.Aliases) {
+		g.resolver.Add(path, links.Aliases)
+		g.addWanting(affected, path, old.Aliases)
+		g.addWanting(affected, path, links.Aliases)
+	}
+	for p := range affected {
+		g.resolve(p)
+	}
+	g.changed[path] = true
+}
+
+// Remove removes the note at path
+func (g *LinkGraph) Remove(path string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	old, ok := g.notes[path]
+	if !ok {
+		return
+	}
+	g.unwant(path, old.Targets)
+	g.resolver.Remove(path)
+	affected := make(map[string]bool)
+	g.addWanting(affected, path, old.Aliases)
+
+	g.setOut(path, nil)
+	delete(g.notes, path)
+	delete(g.broken, path)
+	for p := range affected {
+		if p != path {
+			g.resolve(p)
+		}
+	}
+	delete(g.in, path)
+	delete(g.changed, path)
+	g.terms = true
+}
+
+// Info returns what the vectors of the note at path carry about its links
+func (g *LinkGraph) Info(path string) notescore.LinkInfo {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.info(path)
+}
+
+func (g *LinkGraph) info(path string) notescore.LinkInfo {
+	links := g.out[path]
+	if len(links) > notescore.MaxMetadataLinks {
+		links = links[:notescore.MaxMetadataLinks]
+	}
+	return notescore.LinkInfo{
+		Links:     slices.Clone(links),
+		Backlinks: len(g.in[path]),
+	}
+}
+
+// Preview returns the LinkInfo the note at path would have with links,
+// without changing the graph
+func (g *LinkGraph) Preview(path string, links NoteLinks) notescore.LinkInfo {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	out, _ := g.resolveTargets(path, links.Targets)
+	if len(out) > notescore.MaxMetadataLinks {
+		out = out[:notescore.MaxMetadataLinks]
+	}
+	return notescore.LinkInfo{Links: out, Backlinks: len(g.in[path])}
+}
+
+// NoteLinks returns the recorded links and aliases of the note at path
+func (g *LinkGraph) NoteLinks(path string) (NoteLinks, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	links, ok := g.notes[path]
+	return links, ok
+}
+
+// Links returns the notes the note at path links to, the notes linking to it
+// and its links that resolve to no note, each sorted
+func (g *LinkGraph) Links(path string) (outbound, backlinks, broken []string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for p := range g.in[path] {
+		backlinks = append(backlinks, p)
+	}
+	sort.Strings(backlinks)
+	return slices.Clone(g.out[path]), backlinks, slices.Clone(g.broken[path])
+}
+
+// Resolve returns the note target refers to when written in the note at from
+func (g *LinkGraph) Resolve(from, target string) (string, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.resolver.Resolve(from, target)
+}
+
+// Changed returns the notes whose links or backlinks changed since the last
+// call, sorted, and forgets them
+func (g *LinkGraph) Changed() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	paths := make([]string, 0, len(g.changed))
+	for path := range g.changed {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	g.changed = make(map[string]bool)
+	return paths
+}
+
+// MarkChanged makes the next Changed return path again
+func (g *LinkGraph) MarkChanged(path string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.notes[path]; ok {
+		g.changed[path] = true
+	}
+}
+
+// TagTable returns the tags and aliases of every note, and whether any
+// changed since the last call
+func (g *LinkGraph) TagTable() (*notescore.TagTable, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	table := &notescore.TagTable{Notes: make(map[string]notescore.NoteTerms)}
+	for path, links := range g.notes {
+		if len(links.Tags) > 0 || len(links.Aliases) > 0 {
+			table.Notes[path] = notescore.NoteTerms{Tags: links.Tags, Aliases: links.Aliases}
+		}
+	}
+	changed := g.terms
+	g.terms = false
+	return table, changed
+}
+
+// markTermsChanged makes the next TagTable report a change again
+func (g *LinkGraph) markTermsChanged() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.terms = true
+}
+
+// want and unwant index the targets of the note at path by name
+func (g *LinkGraph) want(path string, targets []string) {
+	for _, target := range targets {
+		addToSet(g.wanted, notescore.LinkName(target), path)
+	}
+}
+
+func (g *LinkGraph) unwant(path string, targets []string) {
+	for _, target := range targets {
+		name := notescore.LinkName(target)
+		delete(g.wanted[name], path)
+		if len(g.wanted[name]) == 0 {
+			delete(g.wanted, name)
+		}
+	}
+}
+
+// addWanting adds to set the notes with links that may resolve to the note at
+// path, by its name or one of aliases
+func (g *LinkGraph) addWanting(set map[string]bool, path string, aliases []string) {
+	for _, name := range append([]string{path}, aliases...) {
+		for p := range g.wanted[notescore.LinkName(name)] {
+			set[p] = true
+		}
+	}
+}
+
+// resolve resolves the links of the note at path again
+func (g *LinkGraph) resolve(path string) {
+	out, broken := g.resolveTargets(path, g.notes[path].Targets)
+	g.broken[path] = broken
+	g.setOut(path, out)
+}
+
+func (g *LinkGraph) resolveTargets(path string, targets []string) ([]string, []string) {
+	var out, broken []string
+	seen := make(map[string]bool)
+	for _, target := range targets {
+		resolved, ok := g.resolver.Resolve(path, target)
+		switch {
+		case !ok:
+			broken = append(broken, target)
+		case resolved != path && !seen[resolved]:
+			seen[resolved] = true
+			out = append(out, resolved)
+		}
+	}
+	sort.Strings(out)
+	sort.Strings(broken)
+	return out, broken
+}
+
+// setOut replaces the notes path links to, updating backlinks and marking
+// every note whose LinkInfo changes
+func (g *LinkGraph) setOut(path string, out []string) {
+	old := g.out[path]
+	if slices.Equal(old, out) {
+		return
+	}
+	for _, target := range old {
+		if !slices.Contains(out, target) {
+			delete(g.in[target], path)
+			g.changed[target] = true
+		}
+	}
+	for _, target := range out {
+		if !slices.Contains(old, target) {
+			addToSet(g.in, target, path)
+			g.changed[target] = true
+		}
+	}
+	if out == nil {
+		delete(g.out, path)
+	} else {
+		g.out[path] = out
+	}
+	g.changed[path] = true
+}
+
+func addToSet(sets map[string]map[string]bool, key, value string) {
+	if sets[key] == nil {
+		sets[key] = make(map[string]bool)
+	}
+	sets[key][value] = true
+}
+
+// UpdateLinkMetadata brings the link metadata of the notes whose links or
+// backlinks changed up to date, in their vectors and in their records. Notes
+// that fail are tried again on the next call.
+func UpdateLinkMetadata(ctx context.Context, vectorDb *notescore.Vector, state *StateStore, graph *LinkGraph, vaultID string) error {
+	var updated, failed int
+	var firstErr error
+	for _, path := range graph.Changed() {
+		changed, err := updateNoteLinks(ctx, vectorDb, state, graph, vaultID, path)
+		if err != nil {
+			graph.MarkChanged(path)
+			failed++
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %w", path, err)
+			}
+		} else if changed {
+			updated++
+		}
+	}
+	if updated > 0 {
+		slog.Info("Updated link metadata", notescore.KeySource, vectorDb.Source(), "notes", updated)
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to update the link metadata of %d notes, first: %w", failed, firstErr)
+	}
+	return nil
+}
+
+// updateNoteLinks updates the link metadata of the note at path if it is out
+// of date and reports whether it was
+func updateNoteLinks(ctx context.Context, vectorDb *notescore.Vector, state *StateStore, graph *LinkGraph, vaultID, path string) (bool, error) {
+	record, ok := state.Get(path)
+	links, known := graph.NoteLinks(path)
+	if !ok || !known {
+		return false, nil
+	}
+	info := graph.Info(path)
+	if record.Links != nil && record.Links.Indexed != nil && record.Links.Indexed.Equal(info) {
+		if record.Links.Tags == nil {
+			// Record the tags parsed on load, so they aren't parsed again
+			links.Indexed = record.Links.Indexed
+			record.Links = &links
+			return false, state.Put(record)
+		}
+		return false, nil
+	}
+
+	ids := record.VectorIds
+	if ids == nil {
+		var err error
+		ids, err = vectorDb.ListIds(ctx, notescore.FileId(vaultID, path))
+		if err != nil {
+			return false, err
+		}
+	}
+	if err := vectorDb.UpdateLinks(ctx, ids, info); err != nil {
+		return false, err
+	}
+	slog.Debug("Updated link metadata", notescore.KeySource, vectorDb.Source(), notescore.KeyPath, path, "links", len(info.Links), "backlinks", info.Backlinks)
+	links.Indexed = &info
+	record.Links = &links
+	return true, state.Put(record)
+}
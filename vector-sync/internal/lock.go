@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// vaultLockFile is locked by the daemon syncing a vault. It lives inside the
+// vault so two daemons are kept apart whatever state directory they use.
+const vaultLockFile = ".vector-notes/sync.lock"
+
+const reindexLockFile = ".vector-notes/reindex.lock"
+
+// ErrLocked is matched by the error returned when another process holds a
+// vault lock
+var ErrLocked = errors.New("vault is locked")
+
+type lockedError struct {
+	root, activity, holder string
+	err                    error
+}
+
+func (e *lockedError) Error() string {
+	if e.holder != "" {
+		return fmt.Sprintf("vault %s is already being %s by process %s", e.root, e.activity, e.holder)
+	}
+	return fmt.Sprintf("vault %s is already being %s by another process: %v", e.root, e.activity, e.err)
+}
+
+func (e *lockedError) Is(target error) bool {
+	return target == ErrLocked
+}
+
+// VaultLock is an exclusive, process-wide lock on a vault
+type VaultLock struct {
+	file *os.File
+}
+
+// LockVault takes the sync lock of the vault at root, failing straight away
+// if another process holds it. The lock is released when the process exits,
+// even if it crashes.
+func LockVault(root string) (*VaultLock, error) {
+	return lockVault(root, vaultLockFile, "synced")
+}
+
+// LockReindex takes the lock that keeps two reindex runs of the vault at root
+// apart. It doesn't conflict with the sync lock.
+func LockReindex(root string) (*VaultLock, error) {
+	return lockVault(root, reindexLockFile, "reindexed")
+}
+
+func lockVault(root, name, activity string) (*VaultLock, error) {
+	path := filepath.Join(root, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(file); err != nil {
+		holder, _ := os.ReadFile(path)
+		file.Close()
+		return nil, &lockedError{root: root, activity: activity, holder: strings.TrimSpace(string(holder)), err: err}
+	}
+
+	file.Truncate(0)
+	file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	return &VaultLock{file: file}, nil
+}
+
+// SyncLockHolder returns the pid of the process syncing the vault at root, or
+// "" if none is. It reads the pid the holder wrote into the lock file rather
+// than trying the lock, which would make a daemon starting at that moment
+// fail to take it. A process that died holding the lock leaves its pid
+// behind, so the pid only counts while that process is alive.
+func SyncLockHolder(root string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(root, vaultLockFile))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	holder := strings.TrimSpace(string(data))
+	pid, err := strconv.Atoi(holder)
+	if err != nil || !processAlive(pid) {
+		return "", nil
+	}
+	return holder, nil
+}
+
+func (l *VaultLock) Unlock() error {
+	l.file.Truncate(0)
+	unlockFile(l.file)
+	return l.file.Close()
+}
@@ -0,0 +1,23 @@
+//go:build !unix && !windows
+
+package internal
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// Vaults can't be locked here, so refuse to sync rather than let two
+// daemons write the same vault
+func lockFile(file *os.File) error {
+	return fmt.Errorf("vault locking is not supported on %s", runtime.GOOS)
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
+
+func processAlive(pid int) bool {
+	return false
+}
@@ -0,0 +1,43 @@
+//go:build windows
+
+package internal
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockRange is the byte locked in the lock file. Windows locks are mandatory,
+// so the lock sits far past the pid the holder writes at the start, which
+// other processes still need to read.
+var lockRange = windows.Overlapped{OffsetHigh: 1}
+
+// stillActive is the exit code GetExitCodeProcess reports for a process
+// that is still running
+const stillActive = 259
+
+func lockFile(file *os.File) error {
+	overlapped := lockRange
+	return windows.LockFileEx(windows.Handle(file.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, &overlapped)
+}
+
+func unlockFile(file *os.File) error {
+	overlapped := lockRange
+	return windows.UnlockFileEx(windows.Handle(file.Fd()), 0, 1, 0, &overlapped)
+}
+
+// processAlive reports whether a process with the given pid is running
+func processAlive(pid int) bool {
+	handle, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		// The process exists but belongs to someone we may not inspect
+		return err == windows.ERROR_ACCESS_DENIED
+	}
+	defer windows.CloseHandle(handle)
+	var code uint32
+	if err := windows.GetExitCodeProcess(handle, &code); err != nil {
+		return true
+	}
+	return code == stillActive
+}
@@ -0,0 +1,40 @@
+package internal
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"notescore"
+)
+
+var (
+	filesTracked = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "vector_sync_files_tracked",
+		Help: "Files recorded in the sync state.",
+	}, []string{"source"})
+	pendingDiffs = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "vector_sync_pending_diffs",
+		Help: "Changes found by the sync in progress that are not handled yet.",
+	}, []string{"source"})
+	retryQueue = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "vector_sync_retry_queue",
+		Help: "Files whose last sync failed and that the next sync retries.",
+	}, []string{"source"})
+	syncDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "vector_sync_sync_duration_seconds",
+		Help:    "Time taken by one sync pass.",
+		Buckets: notescore.DurationBuckets,
+	}, []string{"source"})
+	lastSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "vector_sync_last_success_timestamp_seconds",
+		Help: "Unix time of the last sync pass in which every change succeeded.",
+	}, []string{"source"})
+	watcherEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vector_sync_watcher_events_total",
+		Help: "File system events handled by the watcher, by operation.",
+	}, []string{"source", "op"})
+	watcherErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vector_sync_watcher_errors_total",
+		Help: "Errors reported by the file system watcher.",
+	}, []string{"source"})
+)
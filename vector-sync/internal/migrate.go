@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"notescore"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MigrateLegacyState converts state saved before vault IDs existed. That
+// state is keyed by the vault's absolute path, and its vectors are keyed by a
+// hash of each note's absolute path with the absolute path as metadata. The
+// vectors are re-keyed in place under the vault ID and relative path, then
+// the state file is rewritten under the vault ID. A copy of the old state is
+// kept next to it with a .legacy suffix. It is a no-op once migrated.
+func MigrateLegacyState(ctx context.Context, filename string, tree *Tree, vectorDb *notescore.Vector) error {
+	filePath := filepath.Join(".server", filename)
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var jsonData map[string]*SerializableNode
+	if err := json.Unmarshal(data, &jsonData); err != nil {
+		return err
+	}
+	if _, ok := jsonData[tree.ID]; ok {
+		return nil
+	}
+
+	var legacyRoot string
+	for key := range jsonData {
+		if key != stateRootName && strings.ContainsAny(key, `/\`) {
+			if legacyRoot != "" {
+				return fmt.Errorf("%s holds more than one legacy vault, migrate it manually", filePath)
+			}
+			legacyRoot = key
+		}
+	}
+	if legacyRoot == "" {
+		return nil
+	}
+
+	root := jsonData[legacyRoot]
+	var files []string
+	walkFiles(root, "", func(path string, node *SerializableNode) {
+		files = append(files, path)
+	})
+	slog.Info("Migrating legacy state to vault-relative paths", "files", len(files), notescore.KeyPath, filePath, "root", legacyRoot, "vault_id", tree.ID)
+
+	for _, rel := range files {
+		h := sha256.Sum256([]byte(legacyRoot + rel))
+		oldId := hex.EncodeToString(h[:])
+		moved, err := vectorDb.MoveFile(ctx, oldId, notescore.FileId(tree.ID, rel), rel)
+		if err != nil {
+			return fmt.Errorf("failed to migrate vectors of %s: %w", rel, err)
+		}
+		slog.Info("Migrated vectors", notescore.KeyPath, rel, "vectors", moved)
+	}
+
+	if err := os.WriteFile(filePath+".legacy", data, 0644); err != nil {
+		return err
+	}
+	root.Name = stateRootName
+	migrated, err := json.MarshalIndent(map[string]*SerializableNode{tree.ID: root}, "", "    ")
+	if err != nil {
+		return err
+	}
+	tmp := filePath + ".tmp"
+	if err := os.WriteFile(tmp, migrated, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filePath)
+}
+
+// walkFiles calls fn with the root-relative path of every file node under node
+func walkFiles(node *SerializableNode, currentPath string, fn func(path string, node *SerializableNode)) {
+	for name, child := range node.Children {
+		if strings.HasSuffix(name, "/") {
+			walkFiles(child, currentPath+name, fn)
+		} else {
+			fn(currentPath+name, child)
+		}
+	}
+}
@@ -17,3 +17,26 @@ func NewTreeNode(hash string, name string) *TreeNode {
 func (n *TreeNode) IsDir() bool {
 	return len(n.Name) > 0 && n.Name[len(n.Name)-1] == '/'
 }
+
+// shallowCopy returns a copy of n with its own children map, sharing the
+// children themselves
+func (n *TreeNode) shallowCopy() *TreeNode {
+	copied := &TreeNode{
+		Hash:     n.Hash,
+		Name:     n.Name,
+		Children: make(map[string]*TreeNode, len(n.Children)+1),
+	}
+	for name, child := range n.Children {
+		copied.Children[name] = child
+	}
+	return copied
+}
+
+// deepCopy returns a copy of n and everything below it
+func (n *TreeNode) deepCopy() *TreeNode {
+	copied := NewTreeNode(n.Hash, n.Name)
+	for name, child := range n.Children {
+		copied.Children[name] = child.deepCopy()
+	}
+	return copied
+}
@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"notescore"
+	"os"
+	"path/filepath"
+)
+
+// Rough Pinecone serverless billing, used to estimate what a sync costs. A
+// request that writes or deletes vectors costs a write unit per KB, with a
+// minimum per request. Prices change, so the result is only a guide.
+const (
+	writeUnitBytes = 1024
+	minWriteUnits  = 5
+	writeUnitPrice = 4.0 / 1e6 // US dollars per write unit
+)
+
+// SyncPlan is what a sync would do, worked out without calling the embedder
+// or the index
+type SyncPlan struct {
+	Files          []FilePlan
+	Chunks         int // Chunks to embed
+	Tokens         int // Estimated tokens sent to the embedder
+	EmbeddingCalls int
+	UpsertBytes    int // Estimated size of the upserted vectors
+	Requests       int // Upsert and delete requests to the index
+	DeletedVectors int // Known vectors to delete; see FilePlan.Deleted
+	WriteUnits     int // Estimated index write units
+}
+
+// FilePlan is what a sync would do for one changed file
+type FilePlan struct {
+	Type    DiffType
+	Path    string
+	Chunks  int
+	Tokens  int
+	Bytes   int
+	Deleted int // Vectors to delete, or -1 if they would be listed from the index
+}
+
+// Cost estimates the index cost of the plan in US dollars
+func (p *SyncPlan) Cost() float64 {
+	return float64(p.WriteUnits) * writeUnitPrice
+}
+
+// Count returns how many files of the plan are of the given type
+func (p *SyncPlan) Count(kind DiffType) int {
+	n := 0
+	for _, file := range p.Files {
+		if file.Type == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// planFile works out what syncing one diff would do, reading and chunking
+// the file the way syncFile does
+func (s *Synchronizer) planFile(diff TreeDiff) (FilePlan, error) {
+	plan := FilePlan{Type: diff.Type, Path: diff.Path}
+	record, recorded := s.state.Get(diff.Path)
+	previous := record.VectorIds
+	if recorded && previous == nil {
+		plan.Deleted = -1
+	}
+	if diff.Type == Removed {
+		plan.Deleted = len(previous)
+		if previous == nil {
+			plan.Deleted = -1
+		}
+		return plan, nil
+	}
+
+	absPath := filepath.Join(s.clientTree.RootPath(), diff.Path)
+	content, err := os.ReadFile(absPath)
+	if err != nil {
+		return plan, fmt.Errorf("failed to read file %s: %w", absPath, err)
+	}
+	modified := ""
+	if info, err := os.Stat(absPath); err == nil {
+		modified = fmt.Sprintf("%d", info.ModTime().Unix())
+	}
+
+	id := notescore.FileId(s.serverTree.ID, diff.Path)
+	links := s.links.Preview(diff.Path, ParseNoteLinks(content))
+	keep := make(map[string]bool)
+	for _, chunk := range notescore.ChunkMarkdown(content, notescore.MaxChunkChars) {
+		size, err := s.vectorDb.RecordSize(id, chunk, diff.Path, modified, links, s.dimension)
+		if err != nil {
+			return plan, err
+		}
+		plan.Chunks++
+		text, _ := s.vectorDb.Input().Document(diff.Path, chunk)
+		plan.Tokens += notescore.EstimateTokens(text)
+		plan.Bytes += size
+		keep[notescore.ChunkId(id, chunk.Index)] = true
+	}
+	for _, id := range previous {
+		if !keep[id] {
+			plan.Deleted++
+		}
+	}
+	return plan, nil
+}
+
+// planSync works out what syncing diffs would do
+func (s *Synchronizer) planSync(ctx context.Context, diffs <-chan TreeDiff) (*SyncPlan, error) {
+	plan := &SyncPlan{}
+	for diff := range diffs {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		file, err := s.planFile(diff)
+		if err != nil {
+			return nil, err
+		}
+		plan.Files = append(plan.Files, file)
+
+		plan.Chunks += file.Chunks
+		plan.Tokens += file.Tokens
+		// Every chunk is embedded with its own request
+		plan.EmbeddingCalls += file.Chunks
+		plan.UpsertBytes += file.Bytes
+		if file.Chunks > 0 {
+			plan.Requests++
+			plan.WriteUnits += writeUnits(file.Bytes)
+		}
+		if file.Deleted != 0 {
+			plan.Requests++
+			plan.WriteUnits += minWriteUnits
+		}
+		if file.Deleted > 0 {
+			plan.DeletedVectors += file.Deleted
+		}
+	}
+	return plan, ctx.Err()
+}
+
+func writeUnits(bytes int) int {
+	units := (bytes + writeUnitBytes - 1) / writeUnitBytes
+	if units < minWriteUnits {
+		return minWriteUnits
+	}
+	return units
+}
+
+// logPlan logs every file of plan and what the sync would cost
+func logPlan(plan *SyncPlan) {
+	slog.Info("Dry run", "files", len(plan.Files), "added", plan.Count(Added), "modified", plan.Count(Modified), "removed", plan.Count(Removed))
+	for _, file := range plan.Files {
+		logger := slog.With(notescore.KeyPath, file.Path, "diff_type", file.Type.String())
+		switch {
+		case file.Type == Removed && file.Deleted < 0:
+			logger.Info("Would list the file's vectors from the index and delete them")
+		case file.Type == Removed:
+			logger.Info("Would delete vectors", "vectors", file.Deleted)
+		default:
+			logger.Info("Would embed chunks", "chunks", file.Chunks, "tokens", file.Tokens)
+		}
+	}
+	slog.Info("Would embed", "chunks", plan.Chunks, "tokens", plan.Tokens, "embedding_calls", plan.EmbeddingCalls)
+	slog.Info("Would write to the index",
+		"upsert_kb", fmt.Sprintf("%.1f", float64(plan.UpsertBytes)/1024),
+		"deleted_vectors", plan.DeletedVectors,
+		"requests", plan.Requests,
+		"write_units", plan.WriteUnits,
+		"cost_usd", fmt.Sprintf("%.6f", plan.Cost()))
+}
@@ -0,0 +1,207 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"notescore"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ReindexJob is an unfinished rebuild of a vault's vectors into a new target.
+// It is kept, with the state store of the new target, in ReindexDir until the
+// active index is switched over, so an interrupted run resumes.
+type ReindexJob struct {
+	Target    notescore.ActiveIndex `json:"target"`
+	StartedAt time.Time             `json:"started_at"`
+	// NewNamespace is set when the job named a fresh namespace for the
+	// target, so the namespace holds nothing but the vectors it wrote
+	NewNamespace bool `json:"new_namespace,omitempty"`
+}
+
+const reindexJobFile = "job.json"
+
+// ReindexProgress is reported after every file BuildIndex embeds
+type ReindexProgress struct {
+	Done  int // Files up to date in the target, including ones from earlier runs
+	Total int
+	ETA   time.Duration
+	Path  string
+}
+
+// ReindexDir is where a reindex of the vault keeps its job and state
+func ReindexDir(baseDir, vaultID string) string {
+	return StateDir(baseDir, vaultID) + ".reindex"
+}
+
+// PreviousStateDir keeps the state of the target replaced by the last
+// reindex until it is cleaned up
+func PreviousStateDir(baseDir, vaultID string) string {
+	return StateDir(baseDir, vaultID) + ".previous"
+}
+
+// LoadReindexJob returns the unfinished job in dir, or nil if there is none
+func LoadReindexJob(dir string) (*ReindexJob, error) {
+	data, err := os.ReadFile(filepath.Join(dir, reindexJobFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var job ReindexJob
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", filepath.Join(dir, reindexJobFile), err)
+	}
+	return &job, nil
+}
+
+func SaveReindexJob(dir string, job *ReindexJob) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(job, "", "    ")
+	if err != nil {
+		return err
+	}
+	return notescore.WriteFileAtomic(filepath.Join(dir, reindexJobFile), data)
+}
+
+// BuildIndex brings the target behind vectorDb and state up to date with the
+// files in tree. Files already recorded with the same content and embedder
+// are skipped, which is what makes an interrupted build resumable. The link
+// graph is read from every note first, so the vectors get their final link
+// metadata as they are written. Returns the number of files embedded.
+func BuildIndex(ctx context.Context, tree *Tree, state *StateStore, vectorDb *notescore.Vector, progress func(ReindexProgress)) (int, error) {
+	files := tree.Files()
+	embedder := vectorDb.EmbedderVersion()
+
+	var todo []string
+	for path, hash := range files {
+		record, ok := state.Get(path)
+		if !ok || record.Hash != hash || record.Embedder != embedder {
+			todo = append(todo, path)
+		}
+	}
+	sort.Strings(todo)
+
+	notes := make([]FileRecord, 0, len(files))
+	for path := range files {
+		notes = append(notes, FileRecord{Path: path})
+	}
+	root := tree.RootPath()
+	graph := LoadLinkGraph(notes, root)
+
+	for _, record := range state.Records() {
+		if _, ok := files[record.Path]; !ok {
+			if err := removeFile(ctx, vectorDb, state, graph, tree.ID, record.Path); err != nil {
+				return 0, fmt.Errorf("%s: %w", record.Path, err)
+			}
+		}
+	}
+
+	done := len(files) - len(todo)
+	start := time.Now()
+	for i, path := range todo {
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
+		if err := syncFile(ctx, vectorDb, state, graph, tree.ID, root, path); err != nil {
+			return i, fmt.Errorf("%s: %w", path, err)
+		}
+		perFile := time.Since(start) / time.Duration(i+1)
+		progress(ReindexProgress{
+			Done:  done + i + 1,
+			Total: len(files),
+			ETA:   perFile * time.Duration(len(todo)-i-1),
+			Path:  path,
+		})
+	}
+	// Files skipped above may have been embedded before other notes changed
+	return len(todo), UpdateLinkMetadata(ctx, vectorDb, state, graph, tree.ID)
+}
+
+// SwitchActiveIndex makes the job's target the vault's active index and
+// moves the job's state into place as the vault's state. The replaced target
+// is remembered as Previous until CleanupPrevious. The reindex state store
+// must be closed. If this is interrupted, calling it again completes it.
+func SwitchActiveIndex(root, baseDir, vaultID string, job *ReindexJob, current *notescore.ActiveIndex) error {
+	if !current.SameTarget(job.Target) {
+		next := job.Target
+		next.Since = time.Now().UTC()
+		previous := *current
+		previous.Previous = nil
+		next.Previous = &previous
+		if err := WriteActiveIndex(root, &next); err != nil {
+			return err
+		}
+	}
+
+	// The active index file is the switch. The state directories only need
+	// to follow it, which is repeated on the next run if interrupted here.
+	stateDir := StateDir(baseDir, vaultID)
+	previousDir := PreviousStateDir(baseDir, vaultID)
+	if err := os.RemoveAll(previousDir); err != nil {
+		return err
+	}
+	if _, err := os.Stat(stateDir); err == nil {
+		if err := os.Rename(stateDir, previousDir); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(ReindexDir(baseDir, vaultID), stateDir); err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(stateDir, reindexJobFile))
+}
+
+// CleanupPrevious deletes the vault's vectors from the target replaced by
+// the last reindex, through previousDb, along with its saved state. The
+// vectors are the ones recorded in that state, so other vaults sharing the
+// namespace keep theirs.
+func CleanupPrevious(ctx context.Context, root, baseDir, vaultID string, active *notescore.ActiveIndex, previousDb *notescore.Vector) error {
+	if active.Previous == nil {
+		return nil
+	}
+	if active.Previous.SameTarget(*active) {
+		return fmt.Errorf("previous target is the active one, refusing to delete it")
+	}
+	if _, err := DeleteStateVectors(ctx, PreviousStateDir(baseDir, vaultID), vaultID, previousDb); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(PreviousStateDir(baseDir, vaultID)); err != nil {
+		return err
+	}
+	cleaned := *active
+	cleaned.Previous = nil
+	return WriteActiveIndex(root, &cleaned)
+}
+
+// DeleteStateVectors deletes, through vectorDb, the vectors recorded in the
+// state at dir and returns how many there were. Records from before vector
+// ids were kept have theirs listed by the file's id. Without the state there
+// is no telling the vault's vectors from other vaults', so it fails.
+func DeleteStateVectors(ctx context.Context, dir, vaultID string, vectorDb *notescore.Vector) (int, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return 0, fmt.Errorf("the state of the target is missing, so its vectors can't be told apart from other vaults': %w", err)
+	}
+	records, err := ReadState(dir)
+	if err != nil {
+		return 0, err
+	}
+	var ids []string
+	for _, record := range records {
+		recorded := record.VectorIds
+		if recorded == nil {
+			recorded, err = vectorDb.ListIds(ctx, notescore.FileId(vaultID, record.Path))
+			if err != nil {
+				return 0, fmt.Errorf("failed to list the vectors of %s: %w", record.Path, err)
+			}
+		}
+		ids = append(ids, recorded...)
+	}
+	return len(ids), vectorDb.DeleteIds(ctx, ids)
+}
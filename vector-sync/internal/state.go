@@ -0,0 +1,367 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"notescore"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FileRecord is what the state store knows about one synced file
+type FileRecord struct {
+	Path        string     `json:"path"`         // Relative to the vault root
+	Hash        string     `json:"hash"`         // Hash of the content that was embedded
+	VectorIds   []string   `json:"vector_ids"`   // Ids of the vectors written for the file
+	ChunkHashes []string   `json:"chunk_hashes"` // Hash of each chunk's text, in chunk order
+	SyncedAt    time.Time  `json:"synced_at"`
+	Embedder    string     `json:"embedder"`        // Embedding model and input settings the vectors were made with
+	Links       *NoteLinks `json:"links,omitempty"` // Nil for records from before links were tracked
+}
+
+// StateStore persists the synced state of one vault as a record per file.
+// Every change is appended to a log and fsynced before it returns, so a crash
+// loses at most the change being written; a torn last line is dropped when
+// the log is replayed. Once the log grows it is folded into a snapshot, which
+// is written to a temporary file and renamed into place. StateStore is safe
+// for concurrent use.
+type StateStore struct {
+	mu         sync.Mutex
+	dir        string
+	records    map[string]FileRecord
+	log        *os.File
+	logEntries int
+	closed     bool
+}
+
+const (
+	stateSnapshotFile = "state.json"
+	stateLogFile      = "state.log"
+	stateVersion      = 1
+
+	// compactAfter is the minimum number of log entries before the log is
+	// folded into the snapshot
+	compactAfter = 1000
+)
+
+type stateSnapshot struct {
+	Version int                   `json:"version"`
+	Records map[string]FileRecord `json:"records"`
+}
+
+type stateLogEntry struct {
+	Op     string      `json:"op"` // "put" or "delete"
+	Path   string      `json:"path"`
+	Record *FileRecord `json:"record,omitempty"`
+}
+
+// OpenStateStore opens, or creates, the store in dir by loading the snapshot
+// and replaying the log on top of it
+func OpenStateStore(dir string) (*StateStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &StateStore{dir: dir, records: make(map[string]FileRecord)}
+	if err := s.loadSnapshot(); err != nil {
+		return nil, err
+	}
+
+	var err error
+	s.log, err = os.OpenFile(filepath.Join(dir, stateLogFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.replay(); err != nil {
+		s.log.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *StateStore) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(s.dir, stateSnapshotFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snapshot stateSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", filepath.Join(s.dir, stateSnapshotFile), err)
+	}
+	if snapshot.Version > stateVersion {
+		return fmt.Errorf("%s was written by a newer version (state version %d)", s.dir, snapshot.Version)
+	}
+	for path, record := range snapshot.Records {
+		s.records[path] = record
+	}
+	return nil
+}
+
+// OpenStateStoreReadOnly loads the store in dir without opening it for
+// writing, so it can be read while a daemon holds the store. Put and Delete
+// fail on the returned store.
+func OpenStateStoreReadOnly(dir string) (*StateStore, error) {
+	s := &StateStore{dir: dir, records: make(map[string]FileRecord)}
+	if err := s.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filepath.Join(dir, stateLogFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		defer file.Close()
+		if _, err := s.readLog(file); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// ReadState returns the records stored in dir without opening the store for
+// writing
+func ReadState(dir string) ([]FileRecord, error) {
+	s, err := OpenStateStoreReadOnly(dir)
+	if err != nil {
+		return nil, err
+	}
+	return s.Records(), nil
+}
+
+// replay applies the log to the records. A trailing partial or unreadable
+// line, left by a crash mid-write, is truncated away.
+func (s *StateStore) replay() error {
+	offset, err := s.readLog(s.log)
+	if err != nil {
+		return err
+	}
+	if err := s.log.Truncate(offset); err != nil {
+		return err
+	}
+	_, err = s.log.Seek(offset, io.SeekStart)
+	return err
+}
+
+// readLog applies log entries from r and returns the offset after the last
+// complete entry
+func (s *StateStore) readLog(r io.Reader) (int64, error) {
+	reader := bufio.NewReader(r)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				slog.Warn("Dropping incomplete entry at the end of the state log", notescore.KeyPath, filepath.Join(s.dir, stateLogFile))
+			}
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+		var entry stateLogEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			slog.Warn("Dropping unreadable entries from the state log", notescore.KeyPath, filepath.Join(s.dir, stateLogFile), "offset", offset, notescore.KeyErr, err)
+			return offset, nil
+		}
+		s.apply(entry)
+		s.logEntries++
+		offset += int64(len(line))
+	}
+}
+
+func (s *StateStore) apply(entry stateLogEntry) {
+	switch entry.Op {
+	case "put":
+		if entry.Record != nil {
+			s.records[entry.Path] = *entry.Record
+		}
+	case "delete":
+		delete(s.records, entry.Path)
+	}
+}
+
+// Get returns the record of the file at path
+func (s *StateStore) Get(path string) (FileRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[path]
+	return record, ok
+}
+
+// Put stores record, replacing any record for the same path
+func (s *StateStore) Put(record FileRecord) error {
+	return s.append(stateLogEntry{Op: "put", Path: record.Path, Record: &record})
+}
+
+// Delete removes the record of the file at path
+func (s *StateStore) Delete(path string) error {
+	return s.append(stateLogEntry{Op: "delete", Path: path})
+}
+
+func (s *StateStore) append(entry stateLogEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return fmt.Errorf("state in %s is closed", s.dir)
+	}
+	if s.log == nil {
+		return fmt.Errorf("state in %s is open read-only", s.dir)
+	}
+	if _, err := s.log.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := s.log.Sync(); err != nil {
+		return err
+	}
+	s.apply(entry)
+	s.logEntries++
+
+	if s.logEntries >= compactAfter && s.logEntries >= len(s.records) {
+		if err := s.compact(); err != nil {
+			slog.Error("Error compacting state", "dir", s.dir, notescore.KeyErr, err)
+		}
+	}
+	return nil
+}
+
+// Records returns every record, sorted by path
+func (s *StateStore) Records() []FileRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := make([]FileRecord, 0, len(s.records))
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Path < records[j].Path
+	})
+	return records
+}
+
+func (s *StateStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.records)
+}
+
+// LoadTree replaces the contents of t with the files recorded in the store
+func (s *StateStore) LoadTree(t *Tree) {
+	t.setRoot(recordsRoot(t.RootPath(), s.Records()))
+}
+
+// TreeFromRecords builds the tree of the files in records, as synced, for
+// the vault at rootPath
+func TreeFromRecords(rootPath string, records []FileRecord) *Tree {
+	t := NewTree("", rootPath)
+	t.setRoot(recordsRoot(t.RootPath(), records))
+	return t
+}
+
+func recordsRoot(rootPath string, records []FileRecord) *TreeNode {
+	root := NewTreeNode("", rootPath)
+	for _, record := range records {
+		insertFile(root, record.Path, record.Hash)
+	}
+	return root
+}
+
+// Compact folds the log into the snapshot
+func (s *StateStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.compact()
+}
+
+func (s *StateStore) compact() error {
+	if s.closed {
+		return fmt.Errorf("state in %s is closed", s.dir)
+	}
+	if s.log == nil {
+		return fmt.Errorf("state in %s is open read-only", s.dir)
+	}
+	data, err := json.MarshalIndent(stateSnapshot{Version: stateVersion, Records: s.records}, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := notescore.WriteFileAtomic(filepath.Join(s.dir, stateSnapshotFile), data); err != nil {
+		return err
+	}
+	// The snapshot now holds everything in the log. A crash before the
+	// truncate only means the log is replayed over an identical snapshot.
+	if err := s.log.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := s.log.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	s.logEntries = 0
+	return s.log.Sync()
+}
+
+// Close compacts the store and closes the log. Put and Delete fail once it
+// is closed, so a handler still running at shutdown can't write into a
+// closed log. Closing a read-only store, or closing twice, does nothing.
+func (s *StateStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.log == nil || s.closed {
+		return nil
+	}
+	err := s.compact()
+	s.closed = true
+	if closeErr := s.log.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// ImportLegacyState seeds an empty store from a tree state file written to
+// .server by earlier versions. The imported records have no vector ids, so
+// those are listed from the index the first time the file changes.
+func (s *StateStore) ImportLegacyState(filename string, tree *Tree) error {
+	if s.Len() > 0 {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(".server", filename)); os.IsNotExist(err) {
+		return nil
+	}
+
+	legacy := NewTree("", tree.RootPath())
+	legacy.ID = tree.ID
+	if err := legacy.LoadTreeFromJSON(filename); err != nil {
+		return err
+	}
+	var err error
+	imported := 0
+	walkFiles(legacy.convertToSerializable(legacy.Snapshot().Root), "", func(path string, node *SerializableNode) {
+		if err == nil {
+			err = s.Put(FileRecord{Path: path, Hash: node.Hash})
+			imported++
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if imported > 0 {
+		slog.Info("Imported legacy state", "files", imported, "from", filepath.Join(".server", filename), "dir", s.dir)
+	}
+	return s.Compact()
+}
+
+// StateDir is where the store of the vault with the given ID lives
+func StateDir(baseDir, vaultID string) string {
+	return filepath.Join(baseDir, vaultID)
+}
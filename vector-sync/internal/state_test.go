@@ -0,0 +1,209 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func recordPaths(records []FileRecord) []string {
+	paths := make([]string, len(records))
+	for i, record := range records {
+		paths[i] = record.Path
+	}
+	return paths
+}
+
+func TestStateReplayTornLog(t *testing.T) {
+	tests := []struct {
+		name string
+		tail string // Appended to the log after the complete entries
+		want []string
+	}{
+		{"complete log", "", []string{"a.md", "b.md"}},
+		{"truncated entry", `{"op":"put","path":"c.md","rec`, []string{"a.md", "b.md"}},
+		{"unreadable entry", "not json\n", []string{"a.md", "b.md"}},
+		{"entries after an unreadable one", "not json\n" + `{"op":"delete","path":"a.md"}` + "\n", []string{"a.md", "b.md"}},
+		{"complete delete", `{"op":"delete","path":"a.md"}` + "\n", []string{"b.md"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			store, err := OpenStateStore(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, path := range []string{"a.md", "b.md"} {
+				if err := store.Put(FileRecord{Path: path, Hash: "h-" + path}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Leave the log as a crash would, without compacting it on Close
+			store.log.Close()
+
+			logPath := filepath.Join(dir, stateLogFile)
+			log, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := log.WriteString(test.tail); err != nil {
+				t.Fatal(err)
+			}
+			log.Close()
+
+			readOnly, err := ReadState(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := recordPaths(readOnly); !slices.Equal(got, test.want) {
+				t.Errorf("read-only records: got %v, want %v", got, test.want)
+			}
+
+			store, err = OpenStateStore(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := recordPaths(store.Records()); !slices.Equal(got, test.want) {
+				t.Errorf("records: got %v, want %v", got, test.want)
+			}
+
+			// The torn tail is cut off, so a new entry starts on its own line
+			if err := store.Put(FileRecord{Path: "d.md", Hash: "h-d.md"}); err != nil {
+				t.Fatal(err)
+			}
+			store.log.Close()
+			reopened, err := OpenStateStoreReadOnly(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := append(slices.Clone(test.want), "d.md")
+			if got := recordPaths(reopened.Records()); !slices.Equal(got, want) {
+				t.Errorf("records after a new entry: got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestStateCompactionRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		compact bool // Compact explicitly before reopening
+		close   bool // Close, which compacts too, before reopening
+		after   bool // Write more entries after compacting
+	}{
+		{"log only", false, false, false},
+		{"compacted", true, false, false},
+		{"compacted then logged", true, false, true},
+		{"closed", false, true, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			store, err := OpenStateStore(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]FileRecord{}
+			put := func(record FileRecord) {
+				if err := store.Put(record); err != nil {
+					t.Fatal(err)
+				}
+				want[record.Path] = record
+			}
+			put(FileRecord{Path: "a.md", Hash: "1", VectorIds: []string{"x#0", "x#1"}, ChunkHashes: []string{"c0", "c1"}, Embedder: "model"})
+			put(FileRecord{Path: "dir/b.md", Hash: "2", Links: &NoteLinks{Targets: []string{"a.md"}}})
+			put(FileRecord{Path: "a.md", Hash: "3", VectorIds: []string{"x#0"}})
+			put(FileRecord{Path: "gone.md", Hash: "4"})
+			if err := store.Delete("gone.md"); err != nil {
+				t.Fatal(err)
+			}
+			delete(want, "gone.md")
+
+			if test.compact {
+				if err := store.Compact(); err != nil {
+					t.Fatal(err)
+				}
+				if info, err := os.Stat(filepath.Join(dir, stateLogFile)); err != nil || info.Size() != 0 {
+					t.Errorf("log not emptied by compaction: %v, %v", info, err)
+				}
+			}
+			if test.after {
+				put(FileRecord{Path: "c.md", Hash: "5"})
+				if err := store.Delete("dir/b.md"); err != nil {
+					t.Fatal(err)
+				}
+				delete(want, "dir/b.md")
+			}
+			if test.close {
+				if err := store.Close(); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				store.log.Close()
+			}
+
+			reopened, err := OpenStateStore(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reopened.Close()
+			if reopened.Len() != len(want) {
+				t.Errorf("got %d records, want %d", reopened.Len(), len(want))
+			}
+			for path, record := range want {
+				got, ok := reopened.Get(path)
+				if !ok {
+					t.Errorf("%s missing after reopening", path)
+					continue
+				}
+				if got.Hash != record.Hash || !slices.Equal(got.VectorIds, record.VectorIds) ||
+					!slices.Equal(got.ChunkHashes, record.ChunkHashes) || got.Embedder != record.Embedder ||
+					(got.Links == nil) != (record.Links == nil) {
+					t.Errorf("%s: got %+v, want %+v", path, got, record)
+				}
+			}
+		})
+	}
+}
+
+func TestStateClosed(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenStateStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(FileRecord{Path: "a.md", Hash: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		write func() error
+	}{
+		{"put", func() error { return store.Put(FileRecord{Path: "b.md", Hash: "2"}) }},
+		{"delete", func() error { return store.Delete("a.md") }},
+		{"compact", store.Compact},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.write(); err == nil {
+				t.Errorf("%s on a closed store succeeded", test.name)
+			}
+		})
+	}
+
+	records, err := ReadState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := recordPaths(records); !slices.Equal(got, []string{"a.md"}) {
+		t.Errorf("records after writes to a closed store: got %v, want [a.md]", got)
+	}
+}
@@ -2,154 +2,364 @@ package internal
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
-	"log"
+	"log/slog"
+	"notescore"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
-	"vector-sync/pkg"
-)
-
-const (
-	syncInterval = 5 * time.Second
 )
 
 type Synchronizer struct {
 	clientTree *Tree
 	serverTree *Tree
-	vectorDb   *pkg.Vector
+	vectorDb   *notescore.Vector
+	interval   time.Duration
+	state      *StateStore
+	links      *LinkGraph
+
+	dryRun    bool
+	dimension int // Vector dimension a dry run estimates sizes with
+
+	mu     sync.Mutex
+	failed map[string]bool // Paths whose last sync failed
 }
 
-func NewSynchronizer(ctx context.Context, clientTree *Tree, serverTree *Tree, vectorDb *pkg.Vector) *Synchronizer {
+// StateFileName is the .server tree file earlier versions kept for a source,
+// read once to seed the state store. The default source used server.json.
+func StateFileName(source string) string {
+	if source == DefaultSourceName {
+		return "server.json"
+	}
+	return "server-" + source + ".json"
+}
+
+func NewSynchronizer(ctx context.Context, clientTree *Tree, serverTree *Tree, vectorDb *notescore.Vector, interval time.Duration, state *StateStore) *Synchronizer {
 	return &Synchronizer{
 		clientTree: clientTree,
 		serverTree: serverTree,
 		vectorDb:   vectorDb,
+		interval:   interval,
+		state:      state,
+		links:      LoadLinkGraph(state.Records(), clientTree.RootPath()),
+		failed:     make(map[string]bool),
 	}
 }
 
-func (s *Synchronizer) Start(ctx context.Context) {
-	ticker := time.NewTicker(syncInterval)
+// Start syncs every interval until stop is cancelled and returns once the
+// sync in progress has drained. Files already being synced when stop is
+// cancelled are finished using work, which the caller cancels to abandon
+// them.
+func (s *Synchronizer) Start(stop, work context.Context) {
+	ticker := time.NewTicker(s.interval)
 	defer ticker.Stop()
+	filesTracked.WithLabelValues(s.vectorDb.Source()).Set(float64(s.state.Len()))
 
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				log.Println("Synchronizer stopping...")
-				return
-			case <-ticker.C:
-				if err := s.performSync(ctx); err != nil {
-					log.Printf("Sync error: %v", err)
-				}
+	for {
+		select {
+		case <-stop.Done():
+			slog.Info("Synchronizer exited", notescore.KeySource, s.vectorDb.Source())
+			return
+		case <-ticker.C:
+			if err := s.performSync(stop, work); err != nil && stop.Err() == nil {
+				slog.Error("Sync failed", notescore.KeySource, s.vectorDb.Source(), notescore.KeyErr, err)
 			}
 		}
-	}()
-	<-ctx.Done()
-	log.Println("Synchronizer exited.")
+	}
 }
 
-func (s *Synchronizer) performSync(ctx context.Context) error {
-	log.Println("Checking for changes...")
+// SetDryRun makes every sync only log what it would do, without calling the
+// embedder or the index and without updating the state. Sizes are estimated
+// for vectors of the given dimension.
+func (s *Synchronizer) SetDryRun(dimension int) {
+	s.dryRun = true
+	s.dimension = dimension
+}
+
+// SyncOnce syncs every change between the vault and the state store and
+// returns once they are all handled
+func (s *Synchronizer) SyncOnce(ctx context.Context) error {
+	return s.performSync(ctx, ctx)
+}
 
+// performSync syncs the changes found by one diff. Once stop is cancelled no
+// further files are started; the ones in flight finish using work.
+func (s *Synchronizer) performSync(stop, work context.Context) error {
+	slog.Debug("Checking for changes", notescore.KeySource, s.vectorDb.Source())
+	if s.dryRun {
+		return s.performDryRun(stop)
+	}
+
+	source := s.vectorDb.Source()
+	start := time.Now()
+	err := s.syncChanges(stop, work)
+	// Notes linking to or from the synced ones may need new link metadata,
+	// and note-gpt the new tags and aliases
+	if stop.Err() == nil {
+		if linkErr := UpdateLinkMetadata(work, s.vectorDb, s.state, s.links, s.serverTree.ID); err == nil {
+			err = linkErr
+		}
+		if tagErr := WriteTagTable(s.clientTree.RootPath(), s.links); err == nil {
+			err = tagErr
+		}
+	}
+	notescore.ObserveSince(syncDuration.WithLabelValues(source), start)
+	filesTracked.WithLabelValues(source).Set(float64(s.state.Len()))
+	if err == nil {
+		lastSuccess.WithLabelValues(source).SetToCurrentTime()
+	}
+	return err
+}
+
+// changes starts diffing the vault against the state and returns the channel
+// the changes arrive on, or nil if nothing changed. The server tree is derived
+// from the store, which handlers update. The client tree keeps changing with
+// watcher events, so a snapshot of it is diffed.
+func (s *Synchronizer) changes(ctx context.Context) <-chan TreeDiff {
+	s.state.LoadTree(s.serverTree)
+	client := s.clientTree.Snapshot()
+	if client.RootHash() == s.serverTree.RootHash() {
+		return nil
+	}
 	diffChan := make(chan TreeDiff)
-	var handlerWg sync.WaitGroup
+	go client.CompareAndBuildDiff(ctx, s.serverTree, diffChan)
+	return diffChan
+}
 
-	go s.clientTree.CompareAndBuildDiff(ctx, s.serverTree, diffChan)
+// performDryRun logs what syncing the current changes would do
+func (s *Synchronizer) performDryRun(ctx context.Context) error {
+	diffChan := s.changes(ctx)
+	if diffChan == nil {
+		return nil
+	}
+	plan, err := s.planSync(ctx, diffChan)
+	if err != nil {
+		// Let the diff finish so its goroutine doesn't block
+		for range diffChan {
+		}
+		return err
+	}
+	logPlan(plan)
+	return nil
+}
+
+// syncChanges handles every change found by one diff
+func (s *Synchronizer) syncChanges(stop, work context.Context) error {
+	source := s.vectorDb.Source()
+	diffChan := s.changes(stop)
+	if diffChan == nil {
+		return nil
+	}
 
+	var handlerWg sync.WaitGroup
+	var mu sync.Mutex
+	var changes, failed int
 	for {
 		select {
-		case <-ctx.Done():
-			return ctx.Err()
+		case <-stop.Done():
+			slog.Info("Waiting for files being synced to finish", notescore.KeySource, source)
+			// Let the diff finish so its goroutine doesn't block
+			go func() {
+				for range diffChan {
+				}
+			}()
+			handlerWg.Wait()
+			return stop.Err()
 		case diff, ok := <-diffChan:
 			if !ok {
 				handlerWg.Wait()
-				return s.serverTree.SaveToJSON("server.json")
+				if failed > 0 {
+					return fmt.Errorf("%d of %d changes failed to sync", failed, changes)
+				}
+				return nil
 			}
 
+			changes++
+			pendingDiffs.WithLabelValues(source).Inc()
 			handlerWg.Add(1)
 			go func(d TreeDiff) {
 				defer handlerWg.Done()
-				s.handleDiff(ctx, d)
+				defer pendingDiffs.WithLabelValues(source).Dec()
+				err := s.handleDiff(work, d)
+				s.recordResult(d.Path, err)
+				if err != nil {
+					mu.Lock()
+					failed++
+					mu.Unlock()
+				}
 			}(diff)
 		}
 	}
 }
 
-func (s *Synchronizer) handleDiff(ctx context.Context, diff TreeDiff) {
+// recordResult tracks which paths the next sync retries
+func (s *Synchronizer) recordResult(path string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.failed[path] = true
+	} else {
+		delete(s.failed, path)
+	}
+	retryQueue.WithLabelValues(s.vectorDb.Source()).Set(float64(len(s.failed)))
+}
+
+// handleDiff syncs one change. Everything logged for it carries the source,
+// path and diff type.
+func (s *Synchronizer) handleDiff(ctx context.Context, diff TreeDiff) error {
+	logger := slog.Default().With(notescore.KeySource, s.vectorDb.Source(), notescore.KeyPath, diff.Path, "diff_type", diff.Type.String())
+	ctx = notescore.WithLogger(ctx, logger)
 	switch diff.Type {
 	case Added:
-		s.handleFileAdd(ctx, diff.Path)
+		return s.handleFileAdd(ctx, diff.Path)
 	case Removed:
-		s.handleFileRemove(diff.Path)
+		return s.handleFileRemove(ctx, diff.Path)
 	case Modified:
-		s.handleFileAdd(ctx, diff.Path)
+		return s.handleFileAdd(ctx, diff.Path)
 	default:
-		log.Printf("Unknown diff type: %v for path: %s", diff.Type, diff.Path)
+		logger.Error("Unknown diff type")
+		return nil
 	}
 }
 
-func (s *Synchronizer) handleFileAdd(ctx context.Context, path string) {
-	content, err := s.readFile(path)
+func (s *Synchronizer) handleFileAdd(ctx context.Context, path string) error {
+	logger := notescore.Logger(ctx)
+	logger.Info("Syncing file")
+	start := time.Now()
+	err := syncFile(ctx, s.vectorDb, s.state, s.links, s.serverTree.ID, s.clientTree.RootPath(), path)
 	if err != nil {
-		log.Printf("Error reading file for %s: %v", path, err)
-		return
-	}
-	fileInfo, statErr := os.Stat(path)
-	var fileTime time.Time
-	if statErr != nil {
-		log.Printf("Error getting file info for %s: %v", path, statErr)
-		fileTime = time.Now()
-	} else {
-		fileTime = fileInfo.ModTime()
+		logger.Error("Error syncing file", notescore.KeyDuration, time.Since(start), notescore.KeyErr, err)
+		return err
 	}
+	logger.Info("Synced file", notescore.KeyDuration, time.Since(start))
+	return nil
+}
 
-	log.Printf("File added: %s", path)
-	id := s.createVectorId(path)
-	err = s.vectorDb.Upsert(ctx, id, content, path, fmt.Sprintf("%d", fileTime.Unix()))
+// handleFileRemove deletes the vectors recorded for a removed file. The
+// record is kept when deleting fails so the next sync retries.
+func (s *Synchronizer) handleFileRemove(ctx context.Context, path string) error {
+	logger := notescore.Logger(ctx)
+	start := time.Now()
+	err := removeFile(ctx, s.vectorDb, s.state, s.links, s.serverTree.ID, path)
 	if err != nil {
-		log.Printf("Error upserting vector for %s: %v", path, err)
-		return
+		logger.Error("Error removing file", notescore.KeyDuration, time.Since(start), notescore.KeyErr, err)
+		return err
 	}
-	relativePath := s.getRelativePath(path)
-	s.serverTree.AddNode(relativePath, content)
+	logger.Info("Removed file", notescore.KeyDuration, time.Since(start))
+	return nil
 }
 
-func (s *Synchronizer) handleFileRemove(path string) {
-	log.Printf("File removed: %s", path)
-	relativePath := s.getRelativePath(path)
-	s.serverTree.RemoveNode(relativePath)
+// PendingChanges lists the files that differ between tree and records, sorted
+// by path: what the next sync would embed or delete
+func PendingChanges(ctx context.Context, tree *Tree, records []FileRecord) []TreeDiff {
+	synced := TreeFromRecords(tree.RootPath(), records)
+	diffChan := make(chan TreeDiff)
+	go tree.CompareAndBuildDiff(ctx, synced, diffChan)
+	var diffs []TreeDiff
+	for diff := range diffChan {
+		diffs = append(diffs, diff)
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Path < diffs[j].Path
+	})
+	return diffs
 }
 
-func (s *Synchronizer) handleFileModify(path string) {
-	content, err := s.readFile(path)
+// syncFile embeds the file at the vault-relative path under root into
+// vectorDb and records what was written in state. Its links are added to
+// graph and stored with the vectors.
+func syncFile(ctx context.Context, vectorDb *notescore.Vector, state *StateStore, graph *LinkGraph, vaultID, root, path string) error {
+	absPath := filepath.Join(root, path)
+	content, err := os.ReadFile(absPath)
 	if err != nil {
-		log.Printf("Error handling file modify for %s: %v", path, err)
-		return
+		return fmt.Errorf("failed to read file %s: %w", absPath, err)
+	}
+	fileInfo, statErr := os.Stat(absPath)
+	var fileTime time.Time
+	if statErr != nil {
+		notescore.Logger(ctx).Warn("Error getting file info, using the current time", notescore.KeyErr, statErr)
+		fileTime = time.Now()
+	} else {
+		fileTime = fileInfo.ModTime()
 	}
 
-	log.Printf("File modified: %s", path)
-	relativePath := s.getRelativePath(path)
-	s.serverTree.AddNode(relativePath, content)
-}
+	id := notescore.FileId(vaultID, path)
+	chunks := notescore.ChunkMarkdown(content, notescore.MaxChunkChars)
+	var previous []string
+	if record, ok := state.Get(path); ok && record.VectorIds != nil {
+		previous = record.VectorIds
+	}
+	links := ParseNoteLinks(content)
+	graph.Set(path, links)
+	info := graph.Info(path)
+	ids, err := vectorDb.UpsertChunks(ctx, id, chunks, previous, path, fmt.Sprintf("%d", fileTime.Unix()), info)
+	if err != nil {
+		return fmt.Errorf("failed to upsert vectors: %w", err)
+	}
+	links.Indexed = &info
 
-func (s *Synchronizer) readFile(path string) ([]byte, error) {
-	content, err := os.ReadFile(path)
+	chunkHashes := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		chunkHashes[i] = CalculateHash([]byte(chunk.Text))
+	}
+	err = state.Put(FileRecord{
+		Path:        path,
+		Hash:        CalculateHash(content),
+		VectorIds:   ids,
+		ChunkHashes: chunkHashes,
+		SyncedAt:    time.Now().UTC(),
+		Embedder:    vectorDb.EmbedderVersion(),
+		Links:       &links,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to read file %s: %w", path, err)
+		return fmt.Errorf("failed to save state: %w", err)
 	}
-	return content, nil
+	return nil
 }
 
-func (s *Synchronizer) getRelativePath(path string) string {
-	return strings.Replace(path, s.serverTree.Root.Name, "", 1)
+// removeFile deletes the vectors recorded for path and then its record, and
+// removes it from graph if there is one. Records imported from old state
+// have no ids, so those are listed from the index.
+func removeFile(ctx context.Context, vectorDb *notescore.Vector, state *StateStore, graph *LinkGraph, vaultID, path string) error {
+	record, _ := state.Get(path)
+	ids := record.VectorIds
+	if ids == nil {
+		var err error
+		ids, err = vectorDb.ListIds(ctx, notescore.FileId(vaultID, path))
+		if err != nil {
+			return fmt.Errorf("failed to list vectors: %w", err)
+		}
+	}
+	if err := vectorDb.DeleteIds(ctx, ids); err != nil {
+		return fmt.Errorf("failed to delete vectors: %w", err)
+	}
+	if err := state.Delete(path); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	if graph != nil {
+		graph.Remove(path)
+	}
+	return nil
 }
 
-func (s *Synchronizer) createVectorId(path string) string {
-	h := sha256.New()
-	h.Write([]byte(path))
-	return hex.EncodeToString(h.Sum(nil))
+// ForgetPath deletes the vectors and records of the file at the vault-relative
+// path, or of every file under it if it is a directory, and returns the paths
+// it forgot. The link metadata of notes linking to them is updated when
+// vector-sync next starts.
+func ForgetPath(ctx context.Context, vectorDb *notescore.Vector, state *StateStore, vaultID, path string) ([]string, error) {
+	dir := strings.TrimSuffix(path, "/") + "/"
+	var forgotten []string
+	for _, record := range state.Records() {
+		if record.Path != path && !strings.HasPrefix(record.Path, dir) {
+			continue
+		}
+		if err := removeFile(ctx, vectorDb, state, nil, vaultID, record.Path); err != nil {
+			return forgotten, fmt.Errorf("%s: %w", record.Path, err)
+		}
+		forgotten = append(forgotten, record.Path)
+	}
+	return forgotten, nil
 }
@@ -0,0 +1,34 @@
+package internal
+
+import (
+	"encoding/json"
+	"log/slog"
+	"notescore"
+	"os"
+	"path/filepath"
+)
+
+// The tag table is read by note-gpt, so its type lives in notescore. Only
+// vector-sync writes it.
+
+// WriteTagTable atomically replaces the tag table of the vault at root if
+// any tags or aliases in graph changed since it was last written
+func WriteTagTable(root string, graph *LinkGraph) error {
+	table, changed := graph.TagTable()
+	if !changed {
+		return nil
+	}
+	data, err := json.MarshalIndent(table, "", "    ")
+	if err == nil {
+		path := notescore.TagTablePath(root)
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+			err = notescore.WriteFileAtomic(path, data)
+		}
+	}
+	if err != nil {
+		graph.markTermsChanged()
+		return err
+	}
+	slog.Debug("Wrote tag table", "root", root, "notes", len(table.Notes))
+	return nil
+}
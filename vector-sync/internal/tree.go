@@ -5,15 +5,24 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/fs"
-	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 )
 
+// Tree is a hash tree of the notes under a root directory. It is safe for
+// concurrent use. Nodes are never modified once they are part of the tree:
+// AddNode and RemoveNode copy the nodes along the changed path, recompute
+// only their hashes and swap in the new root. A Snapshot therefore shares all
+// nodes with the tree and stays valid while the tree keeps changing.
 type Tree struct {
-	Root *TreeNode
+	ID     string // Vault ID the tree's state is stored under
+	Root   *TreeNode
+	Ignore *IgnoreRules
+
+	mu sync.RWMutex // Guards Root
 }
 
 type DiffType int
@@ -24,9 +33,21 @@ const (
 	Modified
 )
 
+func (d DiffType) String() string {
+	switch d {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Modified:
+		return "modified"
+	}
+	return fmt.Sprintf("DiffType(%d)", int(d))
+}
+
 type TreeDiff struct {
 	Type DiffType
-	Path string
+	Path string // Relative to the tree root
 }
 
 func NewTree(rootHash string, relativePath string) *Tree {
@@ -34,10 +55,47 @@ func NewTree(rootHash string, relativePath string) *Tree {
 		Root: NewTreeNode(rootHash, strings.TrimSuffix(relativePath, "/")+"/"),
 	}
 }
+
+// Snapshot returns a read-only view of the tree as it is now. Later changes
+// to t don't affect it.
+func (t *Tree) Snapshot() *Tree {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return &Tree{ID: t.ID, Root: t.Root, Ignore: t.Ignore}
+}
+
+// RootPath is the directory the tree was built from, ending in "/"
+func (t *Tree) RootPath() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.Root.Name
+}
+
+// RootHash changes whenever any file under the root does
+func (t *Tree) RootHash() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.Root.Hash
+}
+
+// setRoot calculates the hashes of a freshly built root and makes it the
+// tree's root. root must not be shared with any other tree yet.
+func (t *Tree) setRoot(root *TreeNode) {
+	calculateNodeHash(root)
+	t.mu.Lock()
+	t.Root = root
+	t.mu.Unlock()
+}
+
 func (t *Tree) BuildTree() error {
 	var mu sync.Mutex
 	var wg sync.WaitGroup
 
+	// Files are collected into a private root that is swapped in at the end,
+	// so readers never see a half-built tree
+	rootPath := t.RootPath()
+	root := NewTreeNode("", rootPath)
+
 	numWorkers := 1
 	fileChan := make(chan string, numWorkers)
 
@@ -46,136 +104,242 @@ func (t *Tree) BuildTree() error {
 		go func() {
 			defer wg.Done()
 			for path := range fileChan {
-				content, err := os.ReadFile(filepath.Join(t.Root.Name, path))
+				content, err := os.ReadFile(filepath.Join(rootPath, path))
 				if err != nil {
 					continue
 				}
 				mu.Lock()
-				t.AddNode(path, content)
+				insertFile(root, path, CalculateHash(content))
 				mu.Unlock()
 			}
 		}()
 	}
-	err := fs.WalkDir(os.DirFS(t.Root.Name), ".", func(path string, d fs.DirEntry, err error) error {
+	err := fs.WalkDir(os.DirFS(rootPath), ".", func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-
-		if !d.IsDir() {
-			if strings.HasSuffix(path, ".md") {
-				fileChan <- path
+		if t.Ignore.Match(path) {
+			if d.IsDir() {
+				return fs.SkipDir
 			}
-		} else {
-			// Add directories immediately (they're fast)
-			mu.Lock()
-			t.AddNode(path+"/", nil)
-			mu.Unlock()
+			return nil
+		}
+
+		// Directories are created along with the files in them
+		if !d.IsDir() && strings.HasSuffix(path, ".md") {
+			fileChan <- path
 		}
 		return nil
 	})
 
 	close(fileChan)
 	wg.Wait()
+	t.setRoot(root)
 	return err
 }
 
+// AddNode adds or updates the file at path with the hash of content. With nil
+// content it adds an empty directory, which doesn't count towards hashes.
 func (t *Tree) AddNode(path string, content []byte) {
-	current := t.Root
-	segments := SplitPath(path)
+	segments := pathSegments(path)
+	if len(segments) == 0 {
+		return
+	}
+	hash := ""
+	if content != nil {
+		hash = CalculateHash(content)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Root = withNode(t.Root, segments, hash, content != nil)
+}
+
+// withNode returns a copy of node with the file or directory at segments
+// added. Only the nodes along the path are copied and rehashed.
+func withNode(node *TreeNode, segments []string, hash string, isFile bool) *TreeNode {
+	updated := node.shallowCopy()
+	segment := segments[0]
+	if len(segments) == 1 {
+		if isFile {
+			updated.Children[segment] = NewTreeNode(hash, segment)
+		} else if _, exists := updated.Children[segment]; !exists {
+			updated.Children[segment] = NewTreeNode(CalculateHash(nil), segment)
+		}
+	} else {
+		child, exists := updated.Children[segment]
+		if !exists || !child.IsDir() {
+			child = NewTreeNode("", segment)
+		}
+		updated.Children[segment] = withNode(child, segments[1:], hash, isFile)
+	}
+	updated.Hash = directoryHash(updated)
+	return updated
+}
 
+// insertFile adds a file to a root that is still being built, without
+// copying or hashing. Call calculateNodeHash on the root once it is complete.
+func insertFile(root *TreeNode, path string, hash string) {
+	current := root
+	segments := pathSegments(path)
 	for i, segment := range segments {
-		if segment == "" {
-			continue
+		if i == len(segments)-1 {
+			current.Children[segment] = NewTreeNode(hash, segment)
+			return
 		}
-		isFile := (i == len(segments)-1) && content != nil
 		if _, exists := current.Children[segment]; !exists {
-			if isFile {
-				// It's a file
-				current.Children[segment] = NewTreeNode(CalculateHash(content), segment)
+			current.Children[segment] = NewTreeNode("", segment)
+		}
+		current = current.Children[segment]
+	}
+}
+
+// pathSegments splits a root-relative path into node names, ignoring leading
+// and doubled slashes. A trailing slash marks the last segment as a directory.
+func pathSegments(path string) []string {
+	var segments []string
+	for _, segment := range SplitPath(strings.TrimLeft(path, "/")) {
+		if segment != "" && segment != "/" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+// Files returns the hash of every file in the tree by root-relative path
+func (t *Tree) Files() map[string]string {
+	files := make(map[string]string)
+	var walk func(node *TreeNode, currentPath string)
+	walk = func(node *TreeNode, currentPath string) {
+		for name, child := range node.Children {
+			if child.IsDir() {
+				walk(child, currentPath+name)
 			} else {
-				// It's a directory
-				current.Children[segment] = NewTreeNode("", segment)
-			}
-		} else {
-			if isFile {
-				// Update file content hash if it already exists
-				current.Children[segment].Hash = CalculateHash(content)
+				files[currentPath+name] = child.Hash
 			}
 		}
-		current = current.Children[segment]
 	}
-	t.CalculateDirectoryHashes()
+	walk(t.Snapshot().Root, "")
+	return files
 }
 
+// PrintTree prints the tree to stdout, children sorted by name
 func (t *Tree) PrintTree() {
-	log.Println("************** Tree Structure **************")
-	printNode(t.Root, 0)
+	fmt.Println("************** Tree Structure **************")
+	printNode(t.Snapshot().Root, 0)
 }
 
 func printNode(node *TreeNode, level int) {
 	prefix := strings.Repeat("  ", level)
+	fmt.Printf("%s- %s (hash: %s)\n", prefix, node.Name, node.Hash)
 	if node.IsDir() {
-		fmt.Printf("%s- %s (hash: %s)\n", prefix, node.Name, node.Hash)
-		for _, child := range node.Children {
-			printNode(child, level+1)
+		names := make([]string, 0, len(node.Children))
+		for name := range node.Children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			printNode(node.Children[name], level+1)
 		}
-	} else {
-		fmt.Printf("%s- %s (hash: %s)\n", prefix, node.Name, node.Hash)
 	}
 }
 
+// RemoveNode removes the file or directory at path. Directories left empty
+// are removed as well.
 func (t *Tree) RemoveNode(path string) {
+	segments := pathSegments(path)
+	if len(segments) == 0 {
+		return
+	}
 
-	current := t.Root
-	segments := SplitPath(path)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if updated, ok := withoutNode(t.Root, segments); ok {
+		t.Root = updated
+	}
+}
 
-	for i, segment := range segments {
-		if segment == "" {
-			continue
+// withoutNode returns a copy of node with the node at segments removed, or
+// false if there is no such node. The last segment matches a directory with
+// or without its trailing slash.
+func withoutNode(node *TreeNode, segments []string) (*TreeNode, bool) {
+	segment := segments[0]
+	child, exists := node.Children[segment]
+	if !exists && len(segments) == 1 {
+		segment = strings.TrimSuffix(segment, "/") + "/"
+		child, exists = node.Children[segment]
+	}
+	if !exists {
+		return nil, false
+	}
+
+	updated := node.shallowCopy()
+	if len(segments) == 1 {
+		delete(updated.Children, segment)
+	} else {
+		if !child.IsDir() {
+			return nil, false
 		}
-		if child, exists := current.Children[segment]; exists {
-			if i == len(segments)-1 {
-				delete(current.Children, segment)
-				break
-			}
-			current = child
+		updatedChild, ok := withoutNode(child, segments[1:])
+		if !ok {
+			return nil, false
+		}
+		if len(updatedChild.Children) == 0 {
+			delete(updated.Children, segment)
 		} else {
-			break
+			updated.Children[segment] = updatedChild
 		}
 	}
-	t.CalculateDirectoryHashes()
+	updated.Hash = directoryHash(updated)
+	return updated, true
 }
 
+// CalculateDirectoryHashes recalculates every directory hash. Only needed
+// after building nodes by hand; AddNode and RemoveNode keep hashes current.
 func (t *Tree) CalculateDirectoryHashes() string {
-	hash := calculateNodeHash(t.Root)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	root := t.Root.deepCopy()
+	hash := calculateNodeHash(root)
+	t.Root = root
 	return hash
 }
 
+// calculateNodeHash hashes node and everything below it in place, removing
+// empty directories on the way
 func calculateNodeHash(node *TreeNode) string {
 	if !node.IsDir() {
 		return node.Hash
 	}
 
-	var content strings.Builder
-	childrenToRemove := make([]string, 0)
-
 	for name, child := range node.Children {
-		childHash := calculateNodeHash(child)
-
+		calculateNodeHash(child)
 		if child.IsDir() && len(child.Children) == 0 {
-			childrenToRemove = append(childrenToRemove, name)
-		} else {
-			content.WriteString(childHash)
+			delete(node.Children, name)
 		}
 	}
+	node.Hash = directoryHash(node)
+	return node.Hash
+}
 
-	// Remove empty directories
-	for _, name := range childrenToRemove {
-		delete(node.Children, name)
+// directoryHash hashes the hashes of a directory's children in name order,
+// so equal contents always give equal hashes. Empty directories don't count.
+func directoryHash(node *TreeNode) string {
+	names := make([]string, 0, len(node.Children))
+	for name, child := range node.Children {
+		if child.IsDir() && len(child.Children) == 0 {
+			continue
+		}
+		names = append(names, name)
 	}
+	sort.Strings(names)
 
-	node.Hash = CalculateHash([]byte(content.String()))
-	return node.Hash
+	var content strings.Builder
+	for _, name := range names {
+		content.WriteString(name)
+		content.WriteString(node.Children[name].Hash)
+	}
+	return CalculateHash([]byte(content.String()))
 }
 
 // SerializableNode represents the JSON structure for a tree node
@@ -185,17 +349,23 @@ type SerializableNode struct {
 	Children map[string]*SerializableNode `json:"Children"`
 }
 
-// SaveToJSON saves the tree structure to a JSON file in .client directory
+// stateRootName replaces the local root path in saved state so the state
+// stays valid when the vault moves
+const stateRootName = "/"
+
+// SaveToJSON saves the tree structure to a JSON file in .server directory,
+// keyed by the vault ID
 func (t *Tree) SaveToJSON(filename string) error {
 	clientDir := ".server"
 	if err := os.MkdirAll(clientDir, 0755); err != nil {
 		return err
 	}
 
-	rootData := t.convertToSerializable(t.Root)
+	rootData := t.convertToSerializable(t.Snapshot().Root)
+	rootData.Name = stateRootName
 
 	jsonData := map[string]*SerializableNode{
-		t.Root.Name: rootData,
+		t.ID: rootData,
 	}
 
 	data, err := json.MarshalIndent(jsonData, "", "    ")
@@ -223,6 +393,7 @@ func (t *Tree) convertToSerializable(node *TreeNode) *SerializableNode {
 }
 
 func (t *Tree) LoadTreeFromJSON(filename string) error {
+	rootName := t.RootPath()
 	filePath := filepath.Join(".server", filename)
 	data, err := os.ReadFile(filePath)
 	emptyTree := false
@@ -233,21 +404,22 @@ func (t *Tree) LoadTreeFromJSON(filename string) error {
 	var jsonData map[string]*SerializableNode
 
 	if emptyTree {
-		t.Root = NewTreeNode("", t.Root.Name)
+		t.setRoot(NewTreeNode("", rootName))
 		return nil
 	}
 	if err := json.Unmarshal(data, &jsonData); err != nil {
 		return err
 	}
 
-	if _, exists := jsonData[t.Root.Name]; !exists {
-		t.Root = NewTreeNode("", t.Root.Name)
+	if _, exists := jsonData[t.ID]; !exists {
+		t.setRoot(NewTreeNode("", rootName))
 		return nil
 	}
 
-	// Rebuild the tree from the JSON data
-	t.Root = t.buildFromSerializable(jsonData[t.Root.Name])
-	t.CalculateDirectoryHashes()
+	// Rebuild the tree from the JSON data, rooted at the local vault path
+	root := t.buildFromSerializable(jsonData[t.ID])
+	root.Name = rootName
+	t.setRoot(root)
 	return nil
 }
 
@@ -259,9 +431,12 @@ func (t *Tree) buildFromSerializable(sNode *SerializableNode) *TreeNode {
 	return node
 }
 
+// CompareAndBuildDiff sends every file that differs between t and other to
+// diffChan and closes it. Both trees are compared as snapshots, so changes
+// made during the walk show up in the next diff.
 func (t *Tree) CompareAndBuildDiff(ctx context.Context, other *Tree, diffChan chan<- TreeDiff) {
 	defer close(diffChan)
-	t.compareNodes(ctx, t.Root, other.Root, t.Root.Name, diffChan)
+	t.compareNodes(ctx, t.Snapshot().Root, other.Snapshot().Root, "", diffChan)
 }
 
 func (t *Tree) compareNodes(ctx context.Context, currentNode, otherNode *TreeNode, currentPath string, diffChan chan<- TreeDiff) {
@@ -275,7 +450,10 @@ func (t *Tree) compareNodes(ctx context.Context, currentNode, otherNode *TreeNod
 		childPath := currentPath + name
 		if otherChild, exists := otherNode.Children[name]; exists {
 			if currentChild.IsDir() && otherChild.IsDir() {
-				t.compareNodes(ctx, currentChild, otherChild, childPath, diffChan)
+				// Equal directory hashes mean equal contents
+				if currentChild.Hash != otherChild.Hash {
+					t.compareNodes(ctx, currentChild, otherChild, childPath, diffChan)
+				}
 			} else if !currentChild.IsDir() && !otherChild.IsDir() {
 				if currentChild.Hash != otherChild.Hash {
 					diffChan <- TreeDiff{Type: Modified, Path: childPath}
@@ -285,7 +463,7 @@ func (t *Tree) compareNodes(ctx context.Context, currentNode, otherNode *TreeNod
 			}
 		} else {
 			if currentChild.IsDir() {
-				t.compareNodes(ctx, currentChild, otherNode, childPath, diffChan)
+				t.compareNodes(ctx, currentChild, NewTreeNode("", name), childPath, diffChan)
 			} else {
 				diffChan <- TreeDiff{Type: Added, Path: childPath}
 			}
@@ -293,10 +471,15 @@ func (t *Tree) compareNodes(ctx context.Context, currentNode, otherNode *TreeNod
 		}
 	}
 
-	for name, _ := range otherNode.Children {
+	for name, otherChild := range otherNode.Children {
 		if _, exists := currentNode.Children[name]; !exists {
 			childPath := currentPath + name
-			diffChan <- TreeDiff{Type: Removed, Path: childPath}
+			if otherChild.IsDir() {
+				// Report each file of a removed directory so its vectors go too
+				t.compareNodes(ctx, NewTreeNode("", name), otherChild, childPath, diffChan)
+			} else {
+				diffChan <- TreeDiff{Type: Removed, Path: childPath}
+			}
 		}
 	}
 }
@@ -0,0 +1,154 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sync"
+	"testing"
+)
+
+// Run with -race: the tests below only fail reliably under the race detector
+// when the tree's locking or copy-on-write breaks.
+
+func TestSnapshotUnchangedByLaterWrites(t *testing.T) {
+	tree := NewTree("", "/vault")
+	tree.AddNode("a.md", []byte("a"))
+	tree.AddNode("dir/b.md", []byte("b"))
+	tree.AddNode("dir/sub/c.md", []byte("c"))
+
+	snapshot := tree.Snapshot()
+	files := snapshot.Files()
+	hash := snapshot.RootHash()
+
+	tree.AddNode("dir/b.md", []byte("b changed"))
+	tree.AddNode("dir/sub/d.md", []byte("d"))
+	tree.RemoveNode("a.md")
+	tree.RemoveNode("dir/sub/c.md")
+
+	if got := snapshot.Files(); !maps.Equal(got, files) {
+		t.Errorf("snapshot files changed after writes: got %v, want %v", got, files)
+	}
+	if got := snapshot.RootHash(); got != hash {
+		t.Errorf("snapshot root hash changed after writes: got %s, want %s", got, hash)
+	}
+	if tree.RootHash() == hash {
+		t.Errorf("tree root hash did not change after writes")
+	}
+
+	diffs := make(chan TreeDiff)
+	go tree.CompareAndBuildDiff(context.Background(), snapshot, diffs)
+	got := make(map[string]DiffType)
+	for diff := range diffs {
+		got[diff.Path] = diff.Type
+	}
+	want := map[string]DiffType{
+		"a.md":         Removed,
+		"dir/b.md":     Modified,
+		"dir/sub/c.md": Removed,
+		"dir/sub/d.md": Added,
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("diff against snapshot: got %v, want %v", got, want)
+	}
+}
+
+func TestTreeConcurrentAccess(t *testing.T) {
+	const (
+		writers = 4
+		rounds  = 200
+		readers = 4
+	)
+	tree := NewTree("", "/vault")
+	tree.AddNode("shared/keep.md", []byte("keep"))
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+
+	// Each writer adds, changes and removes files of its own directory
+	var writersWG sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		writersWG.Add(1)
+		go func() {
+			defer writersWG.Done()
+			for i := 0; i < rounds; i++ {
+				path := fmt.Sprintf("w%d/n%d.md", w, i%10)
+				tree.AddNode(path, []byte(fmt.Sprintf("%d", i)))
+				if i%3 == 0 {
+					tree.RemoveNode(path)
+				}
+			}
+		}()
+	}
+
+	// Readers check that a snapshot still reads the same after the writers
+	// have moved on, and diff snapshots while the tree changes
+	errs := make(chan error, readers)
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				snapshot := tree.Snapshot()
+				files := snapshot.Files()
+				hash := snapshot.RootHash()
+				if files["shared/keep.md"] == "" {
+					errs <- fmt.Errorf("snapshot lost shared/keep.md")
+					return
+				}
+
+				diffs := make(chan TreeDiff)
+				go tree.CompareAndBuildDiff(context.Background(), snapshot, diffs)
+				for range diffs {
+				}
+
+				if got := snapshot.Files(); !maps.Equal(got, files) {
+					errs <- fmt.Errorf("snapshot files changed while the tree was written")
+					return
+				}
+				if got := snapshot.RootHash(); got != hash {
+					errs <- fmt.Errorf("snapshot root hash changed while the tree was written")
+					return
+				}
+			}
+		}()
+	}
+
+	writersWG.Wait()
+	close(done)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// Writers left the files of the rounds not divisible by 3, last content
+	// wins
+	want := map[string]string{"shared/keep.md": CalculateHash([]byte("keep"))}
+	for w := 0; w < writers; w++ {
+		for i := 0; i < rounds; i++ {
+			path := fmt.Sprintf("w%d/n%d.md", w, i%10)
+			if i%3 == 0 {
+				delete(want, path)
+			} else {
+				want[path] = CalculateHash([]byte(fmt.Sprintf("%d", i)))
+			}
+		}
+	}
+	if got := tree.Files(); !maps.Equal(got, want) {
+		t.Errorf("files after concurrent writes: got %d files, want %d", len(got), len(want))
+	}
+
+	rebuilt := NewTree("", "/vault")
+	for path, hash := range want {
+		insertFile(rebuilt.Root, path, hash)
+	}
+	if got, want := tree.RootHash(), rebuilt.CalculateDirectoryHashes(); got != want {
+		t.Errorf("root hash after concurrent writes is %s, rebuilding the same files gives %s", got, want)
+	}
+}
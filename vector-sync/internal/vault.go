@@ -0,0 +1,35 @@
+package internal
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"notescore"
+	"os"
+	"path/filepath"
+)
+
+// EnsureVaultID returns the ID of the vault at root, generating and storing a
+// new one the first time the vault is synced
+func EnsureVaultID(root string) (string, error) {
+	id, err := notescore.ReadVaultID(root)
+	if err == nil {
+		return id, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	id = hex.EncodeToString(buf)
+	path := notescore.VaultIDPath(root)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(id+"\n"), 0644); err != nil {
+		return "", err
+	}
+	return id, nil
+}
@@ -0,0 +1,236 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"notescore"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Kinds of problem reported by Verify
+const (
+	ProblemOrphan   = "orphan"   // Vectors no state record accounts for
+	ProblemMissing  = "missing"  // Recorded vectors that are not in the index
+	ProblemStale    = "stale"    // Vectors whose metadata doesn't match their record
+	ProblemEmbedder = "embedder" // File embedded with a different model or input settings than configured
+	ProblemPending  = "pending"  // File changed on disk since it was synced
+	ProblemForeign  = "foreign"  // Vectors of another vault sharing the namespace, never deleted
+)
+
+type VerifyProblem struct {
+	Kind   string
+	Path   string   // File the problem is about, if known
+	Ids    []string // Vectors involved
+	Detail string
+}
+
+// VerifyReport compares one vault's files on disk, its state records and the
+// vectors in its namespace
+type VerifyReport struct {
+	Files    int
+	Records  int
+	Vectors  int
+	Problems []VerifyProblem
+}
+
+// Count returns the number of problems of the given kind
+func (r *VerifyReport) Count(kind string) int {
+	n := 0
+	for _, problem := range r.Problems {
+		if problem.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// Verify lists every vector in the namespace of vectorDb and checks it
+// against the state records of the vault tree was built from. Pending
+// changes are reported for information only; a running daemon syncs them.
+func Verify(ctx context.Context, tree *Tree, records []FileRecord, vectorDb *notescore.Vector, source string) (*VerifyReport, error) {
+	ids, err := vectorDb.ListIds(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list vectors: %w", err)
+	}
+	metadata, err := vectorDb.FetchMetadata(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch vector metadata: %w", err)
+	}
+
+	files := tree.Files()
+	report := &VerifyReport{Files: len(files), Records: len(records), Vectors: len(ids)}
+
+	// Group the index by file id, the part of a vector id before "#"
+	byFile := make(map[string][]string)
+	for _, id := range ids {
+		fileId, _, _ := strings.Cut(id, "#")
+		byFile[fileId] = append(byFile[fileId], id)
+	}
+
+	accounted := make(map[string]bool, len(ids))
+	embedder := vectorDb.EmbedderVersion()
+	for _, record := range records {
+		fileId := notescore.FileId(tree.ID, record.Path)
+		recorded := record.VectorIds
+		if recorded == nil {
+			// Imported from old state without ids: trust what the index has
+			recorded = byFile[fileId]
+			if len(recorded) == 0 {
+				report.add(VerifyProblem{Kind: ProblemMissing, Path: record.Path, Detail: "no vectors in the index"})
+			}
+		}
+
+		var missing, stale []string
+		var staleDetail string
+		for _, id := range recorded {
+			accounted[id] = true
+			meta, ok := metadata[id]
+			if !ok {
+				missing = append(missing, id)
+				continue
+			}
+			if detail := staleMetadata(meta, record.Path, tree.ID, source); detail != "" {
+				stale = append(stale, id)
+				staleDetail = detail
+			}
+		}
+		if len(missing) > 0 {
+			report.add(VerifyProblem{Kind: ProblemMissing, Path: record.Path, Ids: missing,
+				Detail: fmt.Sprintf("%d of %d vectors not in the index", len(missing), len(recorded))})
+		}
+		if len(stale) > 0 {
+			report.add(VerifyProblem{Kind: ProblemStale, Path: record.Path, Ids: stale, Detail: staleDetail})
+		}
+		if record.Embedder != embedder {
+			used := record.Embedder
+			if used == "" {
+				used = "unknown model"
+			}
+			report.add(VerifyProblem{Kind: ProblemEmbedder, Path: record.Path,
+				Detail: fmt.Sprintf("embedded with %s, configured %s", used, embedder)})
+		}
+
+		if hash, ok := files[record.Path]; !ok {
+			report.add(VerifyProblem{Kind: ProblemPending, Path: record.Path, Detail: "deleted on disk"})
+		} else if hash != record.Hash {
+			report.add(VerifyProblem{Kind: ProblemPending, Path: record.Path, Detail: "changed on disk"})
+		}
+	}
+
+	recordedPaths := make(map[string]bool, len(records))
+	for _, record := range records {
+		recordedPaths[record.Path] = true
+	}
+	for path := range files {
+		if !recordedPaths[path] {
+			report.add(VerifyProblem{Kind: ProblemPending, Path: path, Detail: "not synced yet"})
+		}
+	}
+
+	// Only vectors that belong to this vault can be orphans. A namespace may
+	// be shared, and another vault's vectors are its own state's business.
+	orphans := make(map[string][]string)
+	foreign := make(map[string][]string) // Ids by vault ID
+	for _, id := range ids {
+		if accounted[id] {
+			continue
+		}
+		meta := notescore.ParseChunkMetadata(metadata[id])
+		path, ok := vaultVectorPath(meta, tree)
+		if !ok {
+			foreign[meta.VaultID] = append(foreign[meta.VaultID], id)
+			continue
+		}
+		orphans[path] = append(orphans[path], id)
+	}
+	for path, orphanIds := range orphans {
+		report.add(VerifyProblem{Kind: ProblemOrphan, Path: path, Ids: orphanIds,
+			Detail: fmt.Sprintf("%d vectors with no state record", len(orphanIds))})
+	}
+	for vaultID, foreignIds := range foreign {
+		detail := fmt.Sprintf("%d vectors from vault %s, left alone", len(foreignIds), vaultID)
+		if vaultID == "" {
+			detail = fmt.Sprintf("%d vectors of no known vault, left alone", len(foreignIds))
+		}
+		report.add(VerifyProblem{Kind: ProblemForeign, Ids: foreignIds, Detail: detail})
+	}
+
+	sort.SliceStable(report.Problems, func(i, j int) bool {
+		if report.Problems[i].Kind != report.Problems[j].Kind {
+			return report.Problems[i].Kind < report.Problems[j].Kind
+		}
+		return report.Problems[i].Path < report.Problems[j].Path
+	})
+	return report, nil
+}
+
+func (r *VerifyReport) add(problem VerifyProblem) {
+	r.Problems = append(r.Problems, problem)
+}
+
+// vaultVectorPath returns the path of the note a vector was written for when
+// the vector belongs to the vault of tree: its vault_id is the vault's, or it
+// is a legacy vector without one whose absolute filepath is inside the vault
+func vaultVectorPath(meta notescore.ChunkMetadata, tree *Tree) (string, bool) {
+	if meta.VaultID != "" {
+		return meta.Path, meta.VaultID == tree.ID
+	}
+	if meta.Filepath == "" {
+		return "", false
+	}
+	rel, err := filepath.Rel(tree.RootPath(), meta.Filepath)
+	if err != nil || !filepath.IsLocal(rel) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// staleMetadata describes how a vector's metadata differs from what
+// vector-sync would write for the file now, or returns ""
+func staleMetadata(fields map[string]interface{}, path, vaultID, source string) string {
+	meta := notescore.ParseChunkMetadata(fields)
+	switch {
+	case meta.Filepath != "":
+		return "metadata still has an absolute filepath"
+	case meta.Schema != notescore.SchemaVersion:
+		return fmt.Sprintf("metadata schema is %d, not %d", meta.Schema, notescore.SchemaVersion)
+	case meta.Path != path:
+		return fmt.Sprintf("metadata path is %q", meta.Path)
+	case meta.VaultID != vaultID:
+		return fmt.Sprintf("metadata vault_id is %q", meta.VaultID)
+	case meta.Source != source:
+		return fmt.Sprintf("metadata source is %q", meta.Source)
+	}
+	return ""
+}
+
+// FixVerifyProblems deletes orphaned vectors, never foreign ones, and removes the state records of
+// files with missing, stale or differently embedded vectors, so the next sync
+// embeds them again. Returns the number of vectors deleted and files
+// re-queued.
+func FixVerifyProblems(ctx context.Context, report *VerifyReport, state *StateStore, vectorDb *notescore.Vector) (int, int, error) {
+	var orphans []string
+	requeue := make(map[string]bool)
+	for _, problem := range report.Problems {
+		switch problem.Kind {
+		case ProblemOrphan:
+			orphans = append(orphans, problem.Ids...)
+		case ProblemMissing, ProblemStale, ProblemEmbedder:
+			requeue[problem.Path] = true
+		}
+	}
+
+	if err := vectorDb.DeleteIds(ctx, orphans); err != nil {
+		return 0, 0, fmt.Errorf("failed to delete orphaned vectors: %w", err)
+	}
+	requeued := 0
+	for path := range requeue {
+		if err := state.Delete(path); err != nil {
+			return len(orphans), requeued, err
+		}
+		requeued++
+	}
+	return len(orphans), requeued, nil
+}
@@ -3,7 +3,8 @@ package internal
 import (
 	"context"
 	"io/fs"
-	"log"
+	"log/slog"
+	"notescore"
 	"os"
 	"path/filepath"
 	"strings"
@@ -15,13 +16,14 @@ import (
 type FileWatcher struct {
 	watcher *fsnotify.Watcher
 	tree    *Tree
+	source  string // Source name the watcher's metrics are labelled with
 	mu      sync.RWMutex
 	ctx     context.Context
 	cancel  context.CancelFunc
 	wg      sync.WaitGroup
 }
 
-func NewFileWatcher(ctx context.Context, tree *Tree) (*FileWatcher, error) {
+func NewFileWatcher(ctx context.Context, tree *Tree, source string) (*FileWatcher, error) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return nil, err
@@ -30,6 +32,7 @@ func NewFileWatcher(ctx context.Context, tree *Tree) (*FileWatcher, error) {
 	fw := &FileWatcher{
 		watcher: watcher,
 		tree:    tree,
+		source:  source,
 		ctx:     ctx,
 		cancel:  cancel,
 	}
@@ -37,14 +40,17 @@ func NewFileWatcher(ctx context.Context, tree *Tree) (*FileWatcher, error) {
 }
 
 func (fw *FileWatcher) StartWatching() error {
-	log.Printf("Starting to watch directory: %s", fw.tree.Root.Name)
+	slog.Info("Starting to watch directory", notescore.KeySource, fw.source, "dir", fw.tree.RootPath())
 	defer fw.watcher.Close()
 
-	err := filepath.Walk((fw.tree.Root.Name), func(path string, info fs.FileInfo, err error) error {
+	err := filepath.Walk((fw.tree.RootPath()), func(path string, info fs.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 		if info.IsDir() {
+			if fw.isIgnored(path) {
+				return filepath.SkipDir
+			}
 			return fw.watcher.Add(path)
 		}
 		return nil
@@ -61,12 +67,12 @@ func (fw *FileWatcher) StartWatching() error {
 
 func (fw *FileWatcher) watchLoop() {
 	defer fw.wg.Done()
-	log.Println("File watcher loop started")
+	slog.Debug("File watcher loop started", notescore.KeySource, fw.source)
 	for {
 		select {
 		case event, ok := <-fw.watcher.Events:
 			if !ok {
-				log.Println("File watcher events channel closed")
+				slog.Debug("File watcher events channel closed", notescore.KeySource, fw.source)
 				return
 			}
 			fw.handleEvent(event)
@@ -74,7 +80,8 @@ func (fw *FileWatcher) watchLoop() {
 			if !ok {
 				return
 			}
-			log.Printf("File watcher error: %v", err)
+			slog.Error("File watcher error", notescore.KeySource, fw.source, notescore.KeyErr, err)
+			watcherErrors.WithLabelValues(fw.source).Inc()
 			// Handle error (log it, etc.)
 			_ = err
 		case <-fw.ctx.Done():
@@ -93,17 +100,24 @@ func (fw *FileWatcher) handleEvent(event fsnotify.Event) {
 	if !strings.HasSuffix(event.Name, ".md") && !fw.isDirectory(event.Name) {
 		return
 	}
+	if fw.isIgnored(event.Name) {
+		return
+	}
 	fw.mu.Lock()
 	defer fw.mu.Unlock()
 
 	switch {
 	case event.Op&fsnotify.Create == fsnotify.Create:
+		watcherEvents.WithLabelValues(fw.source, "create").Inc()
 		fw.handleCreate(event.Name)
 	case event.Op&fsnotify.Write == fsnotify.Write:
+		watcherEvents.WithLabelValues(fw.source, "write").Inc()
 		fw.handleWrite(event.Name)
 	case event.Op&fsnotify.Remove == fsnotify.Remove:
+		watcherEvents.WithLabelValues(fw.source, "remove").Inc()
 		fw.handleRemove(event.Name)
 	case event.Op&fsnotify.Rename == fsnotify.Rename:
+		watcherEvents.WithLabelValues(fw.source, "rename").Inc()
 		fw.handleRemove(event.Name)
 	}
 }
@@ -111,14 +125,14 @@ func (fw *FileWatcher) handleEvent(event fsnotify.Event) {
 func (fw *FileWatcher) handleCreate(path string) {
 	if fw.isDirectory(path) {
 		fw.watcher.Add(path)
-	} else if strings.HasSuffix(strings.TrimPrefix(path, fw.tree.Root.Name+"/"), ".md") {
+	} else if strings.HasSuffix(strings.TrimPrefix(path, fw.tree.RootPath()+"/"), ".md") {
 		content, err := os.ReadFile(path)
 		if err != nil {
 			// Handle error (log it, etc.)
 			return
 		}
 
-		fw.tree.AddNode(strings.TrimPrefix(path, fw.tree.Root.Name), content)
+		fw.tree.AddNode(strings.TrimPrefix(path, fw.tree.RootPath()), content)
 	}
 }
 
@@ -128,21 +142,25 @@ func (fw *FileWatcher) handleWrite(path string) {
 	}
 	content, err := os.ReadFile(path)
 	if err != nil {
-		log.Printf("Error reading file %s: %v", path, err)
+		slog.Error("Error reading file", notescore.KeySource, fw.source, notescore.KeyPath, path, notescore.KeyErr, err)
 		return
 	}
-	fw.tree.AddNode(strings.TrimPrefix(path, fw.tree.Root.Name), content)
+	fw.tree.AddNode(strings.TrimPrefix(path, fw.tree.RootPath()), content)
 }
 
 func (fw *FileWatcher) handleRemove(path string) {
 	if fw.isDirectory(path) {
 		fw.watcher.Remove(path)
-		fw.tree.RemoveNode(strings.TrimPrefix(path, fw.tree.Root.Name) + "/")
-	} else if strings.HasSuffix(strings.TrimPrefix(path, fw.tree.Root.Name), ".md") {
-		fw.tree.RemoveNode(strings.TrimPrefix(path, fw.tree.Root.Name))
+		fw.tree.RemoveNode(strings.TrimPrefix(path, fw.tree.RootPath()) + "/")
+	} else if strings.HasSuffix(strings.TrimPrefix(path, fw.tree.RootPath()), ".md") {
+		fw.tree.RemoveNode(strings.TrimPrefix(path, fw.tree.RootPath()))
 	}
 }
 
+func (fw *FileWatcher) isIgnored(path string) bool {
+	return fw.tree.Ignore.Match(strings.TrimPrefix(path, fw.tree.RootPath()))
+}
+
 func (fw *FileWatcher) isDirectory(path string) bool {
 	info, err := os.Stat(path)
 	if err != nil {
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"vector-sync/internal"
+)
+
+// runLinks implements `vector-sync links`, which lists the outbound links,
+// backlinks and broken links of a note as of the last sync. It only reads,
+// so it is safe while the daemon runs.
+func runLinks(args []string) int {
+	fs := flag.NewFlagSet("links", flag.ContinueOnError)
+	only := fs.String("source", "", "only look in this source")
+	var flags internal.Flags
+	flags.Register(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vector-sync links [-source name] [flags] <note>")
+		fmt.Fprintln(fs.Output(), "\nThe note is a vault-relative path, a note name or an alias.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	note := fs.Arg(0)
+
+	config, err := internal.LoadConfig(flags)
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		return exitFailed
+	}
+	sources, ok := selectSources(config, *only)
+	if !ok {
+		return exitUsage
+	}
+
+	found := false
+	for _, source := range sources {
+		state, err := readSourceState(source, config)
+		if err != nil {
+			fmt.Printf("Error reading source %s: %v\n", source.Name, err)
+			return exitFailed
+		}
+		graph := internal.LoadLinkGraph(state.records, source.Path)
+		path, ok := findNote(graph, source, note)
+		if !ok {
+			continue
+		}
+		if found {
+			fmt.Println()
+		}
+		found = true
+		printLinks(source, path, graph)
+	}
+	if !found {
+		fmt.Printf("No synced note matches %q\n", note)
+		return exitFailed
+	}
+	return exitOK
+}
+
+// findNote maps the note given on the command line to a synced note of
+// source: an absolute path inside the vault, a vault-relative path, or a
+// name or alias as a link would use it
+func findNote(graph *internal.LinkGraph, source internal.SourceConfig, note string) (string, bool) {
+	if filepath.IsAbs(note) {
+		rel, err := filepath.Rel(source.Path, note)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", false
+		}
+		note = rel
+	}
+	note = filepath.ToSlash(note)
+	for _, candidate := range []string{note, note + ".md"} {
+		if _, ok := graph.NoteLinks(candidate); ok {
+			return candidate, true
+		}
+	}
+	return graph.Resolve("", note)
+}
+
+func printLinks(source internal.SourceConfig, path string, graph *internal.LinkGraph) {
+	outbound, backlinks, broken := graph.Links(path)
+	fmt.Printf("%s  (source %s)\n", path, source.Name)
+	links, _ := graph.NoteLinks(path)
+	if len(links.Aliases) > 0 {
+		fmt.Printf("  aliases: %s\n", strings.Join(links.Aliases, ", "))
+	}
+	if len(links.Tags) > 0 {
+		fmt.Printf("  tags: #%s\n", strings.Join(links.Tags, ", #"))
+	}
+	printPaths("links", outbound)
+	printPaths("backlinks", backlinks)
+	printPaths("broken", broken)
+}
+
+func printPaths(heading string, paths []string) {
+	fmt.Printf("  %s (%d)\n", heading, len(paths))
+	for _, path := range paths {
+		fmt.Printf("    %s\n", path)
+	}
+}
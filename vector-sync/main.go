@@ -2,49 +2,449 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
+	"notescore"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 	"vector-sync/internal"
-	"vector-sync/pkg"
 )
 
+// Exit codes shared by all commands
+const (
+	exitOK     = 0 // Done, nothing failed
+	exitFailed = 1 // The command ran but failed, or found problems
+	exitUsage  = 2 // Bad arguments
+	exitLocked = 3 // Another vector-sync process holds the vault
+)
+
+var commands = map[string]func(args []string) int{
+	"run":     runDaemon,
+	"once":    runOnce,
+	"status":  runStatus,
+	"tree":    runTree,
+	"diff":    runDiff,
+	"links":   runLinks,
+	"dupes":   runDupes,
+	"forget":  runForget,
+	"verify":  runVerify,
+	"reindex": runReindex,
+	"config":  runConfig,
+}
+
+const usage = `usage: vector-sync [command] [flags]
+
+Commands:
+  run       watch the vaults and sync changes until interrupted (default)
+  once      sync every pending change once and exit
+  status    show pending changes and the last sync of each source
+  tree      print the synced tree of a source
+  diff      list the changes the next sync would make, without syncing
+  links     list a note's links, backlinks and broken links
+  dupes     list duplicate and near-duplicate notes
+  forget    delete a file's or directory's vectors and sync state
+  verify    reconcile the index with the sync state
+  reindex   re-embed a source into a new namespace and switch to it
+  config    check the configuration
+
+Run vector-sync <command> -h for the flags of a command.`
+
 func main() {
-	config, err := internal.LoadConfig()
+	// Without a command, or with only flags, run the daemon as before
+	if len(os.Args) < 2 || strings.HasPrefix(os.Args[1], "-") {
+		os.Exit(runDaemon(os.Args[1:]))
+	}
+	if os.Args[1] == "help" {
+		fmt.Println(usage)
+		os.Exit(exitOK)
+	}
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Printf("unknown command %q\n\n%s\n", os.Args[1], usage)
+		os.Exit(exitUsage)
+	}
+	os.Exit(command(os.Args[2:]))
+}
+
+// exitCode maps the error a command failed with to its exit code
+func exitCode(err error) int {
+	if errors.Is(err, internal.ErrLocked) {
+		return exitLocked
+	}
+	return exitFailed
+}
+
+// runDaemon implements `vector-sync run`, which watches every source and
+// syncs its changes until interrupted
+func runDaemon(args []string) int {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	var flags internal.Flags
+	flags.Register(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), usage)
+		fmt.Fprintln(fs.Output(), "\nFlags:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	config, err := internal.LoadConfig(flags)
 	if err != nil {
 		fmt.Printf("Error loading config: %v\n", err)
-		return
+		return exitFailed
+	}
+	// stop ends watching and starting new files; work is what files already
+	// being synced use, and is only cancelled once the shutdown deadline passes
+	stop, stopSyncing := context.WithCancel(context.Background())
+	defer stopSyncing()
+	work, abandon := context.WithCancel(context.Background())
+	defer abandon()
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	var health *healthServer
+	if config.Metrics.Listen != "" {
+		health, err = startHealthServer(config.Metrics.Listen)
+		if err != nil {
+			fmt.Println(err)
+			return exitFailed
+		}
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		health.Shutdown(ctx)
+	}()
+
+	var running []*runningSource
+	defer func() {
+		for _, source := range running {
+			source.close()
+		}
+	}()
+	for _, source := range config.AllSources() {
+		started, err := startSource(stop, work, source, config)
+		if err != nil {
+			slog.Error("Error starting source", notescore.KeySource, source.Name, notescore.KeyErr, err)
+			stopSyncing()
+			abandon()
+			waitStopped(running, abandonTimeout)
+			return exitCode(err)
+		}
+		running = append(running, started)
+	}
+	health.SetReady(true)
+
+	sig := <-signals
+	slog.Info("Shutting down, send the signal again to quit immediately", "signal", sig.String())
+	health.SetReady(false)
+	stopSyncing()
+	go func() {
+		<-signals
+		slog.Warn("Quitting without waiting for files being synced")
+		os.Exit(exitFailed)
+	}()
+
+	if waitStopped(running, config.Sync.ShutdownTimeout) {
+		slog.Info("Shut down cleanly")
+		return exitOK
 	}
-	vectorDb, err := pkg.NewVector(config.PineconeAPIKey, config.PineconeHost, "joyful-elm", config.EmbeddingUrl)
+	// Abandoned files keep their old record, so the next run syncs them again
+	slog.Warn("Files still syncing at the shutdown deadline, abandoning them", "timeout", config.Sync.ShutdownTimeout)
+	abandon()
+	// Closing the sources under handlers still writing would fail their
+	// writes half way, so give them a moment to see the cancellation
+	if !waitStopped(running, abandonTimeout) {
+		slog.Warn("Files still syncing after abandoning them, closing anyway", "timeout", abandonTimeout)
+	}
+	return exitOK
+}
+
+// abandonTimeout bounds the wait for sources to stop once the files they are
+// syncing have been abandoned
+const abandonTimeout = 5 * time.Second
+
+// waitStopped waits up to timeout for every source to stop watching and
+// syncing, and reports whether they all did
+func waitStopped(sources []*runningSource, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for _, source := range sources {
+		select {
+		case <-source.done:
+		case <-deadline:
+			return false
+		}
+	}
+	return true
+}
+
+// runOnce implements `vector-sync once`, which syncs every pending change of
+// each source and exits, for running from cron. It fails with exitLocked
+// while a daemon is syncing a source.
+func runOnce(args []string) int {
+	fs := flag.NewFlagSet("once", flag.ContinueOnError)
+	only := fs.String("source", "", "only sync this source")
+	dryRun := fs.Bool("dry-run", false, "only report what would be synced and what it would cost")
+	dimension := fs.Int("dimension", 0, "vector dimension a dry run assumes (default: the active index's, or 768)")
+	var flags internal.Flags
+	flags.Register(fs)
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	config, err := internal.LoadConfig(flags)
 	if err != nil {
-		fmt.Printf("Error creating vector database client: %v\n", err)
-		return
+		fmt.Printf("Error loading config: %v\n", err)
+		return exitFailed
+	}
+	sources, ok := selectSources(config, *only)
+	if !ok {
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	code := exitOK
+	for _, source := range sources {
+		syncSource := syncSourceOnce
+		if *dryRun {
+			syncSource = func(ctx context.Context, source internal.SourceConfig, config *internal.Config) error {
+				return dryRunSource(ctx, source, config, *dimension)
+			}
+		}
+		if err := syncSource(ctx, source, config); err != nil {
+			fmt.Printf("Error syncing source %s: %v\n", source.Name, err)
+			if code == exitOK || exitCode(err) == exitLocked {
+				code = exitCode(err)
+			}
+		}
 	}
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	clientTree := internal.NewTree("", config.NotesDir)
-	serverTree := internal.NewTree("", config.NotesDir)
-	err = clientTree.BuildTree()
-	serverTree.LoadTreeFromJSON("server.json")
+	return code
+}
+
+func syncSourceOnce(ctx context.Context, source internal.SourceConfig, config *internal.Config) error {
+	opened, err := openSource(ctx, source, config)
 	if err != nil {
-		fmt.Printf("Error building tree: %v\n", err)
-		return
+		return err
 	}
+	defer opened.close()
+	synchronizer := internal.NewSynchronizer(ctx, opened.clientTree, opened.serverTree, opened.vectorDb, config.Sync.Interval, opened.state)
+	return synchronizer.SyncOnce(ctx)
+}
 
-	watcher, err := internal.NewFileWatcher(ctx, clientTree)
+// defaultDimension is what a dry run assumes when the dimension of the active
+// index was never recorded. It is nomic-embed-text's.
+const defaultDimension = 768
+
+// dryRunSource logs what syncing source would do. It takes no lock and writes
+// nothing, not even the vault ID of a vault that was never synced.
+func dryRunSource(ctx context.Context, source internal.SourceConfig, config *internal.Config, dimension int) error {
+	vaultID, err := notescore.ReadVaultID(source.Path)
+	if os.IsNotExist(err) {
+		// Only the length of the ID matters for the estimate
+		vaultID = strings.Repeat("0", 32)
+	} else if err != nil {
+		return err
+	}
+	state, err := internal.OpenStateStoreReadOnly(internal.StateDir(config.Sync.StateDir, vaultID))
+	if err != nil {
+		return fmt.Errorf("error reading state: %w", err)
+	}
+	active, err := notescore.ReadActiveIndex(source.Path)
 	if err != nil {
-		fmt.Printf("Error creating file watcher: %v\n", err)
-		return
+		return err
+	}
+	if active == nil {
+		configured := configuredIndex(config, source)
+		active = &configured
+	}
+	if dimension == 0 {
+		dimension = active.Dimension
 	}
+	if dimension == 0 {
+		dimension = defaultDimension
+		slog.Warn("Index dimension unknown, pass -dimension to change the assumed one", "index", active.String(), "dimension", dimension)
+	}
+	sourceDb, err := connectIndex(config, *active, source, vaultID)
+	if err != nil {
+		return fmt.Errorf("error creating vector database client: %w", err)
+	}
+
+	clientTree := internal.NewTree("", source.Path)
+	clientTree.ID = vaultID
+	clientTree.Ignore = internal.NewIgnoreRules(source.Ignore)
+	if err := clientTree.BuildTree(); err != nil {
+		return fmt.Errorf("error building tree: %w", err)
+	}
+	serverTree := internal.NewTree("", source.Path)
+	serverTree.ID = vaultID
+
+	slog.Info("Dry run", notescore.KeySource, source.Name, "index", active.String())
+	synchronizer := internal.NewSynchronizer(ctx, clientTree, serverTree, sourceDb, config.Sync.Interval, state)
+	synchronizer.SetDryRun(dimension)
+	return synchronizer.SyncOnce(ctx)
+}
+
+// selectSources returns the source named only, or every source if only is
+// empty. It reports an unknown name and returns false.
+func selectSources(config *internal.Config, only string) ([]internal.SourceConfig, bool) {
+	if only == "" {
+		return config.AllSources(), true
+	}
+	for _, source := range config.AllSources() {
+		if source.Name == only {
+			return []internal.SourceConfig{source}, true
+		}
+	}
+	fmt.Printf("Unknown source %q\n", only)
+	return nil, false
+}
+
+// openedSource is a source ready to sync: locked, connected to its active
+// index, with its state store open and the tree of its files built
+type openedSource struct {
+	clientTree *internal.Tree
+	serverTree *internal.Tree
+	vectorDb   *notescore.Vector
+	state      *internal.StateStore
+	close      func() // Closes the state store and the index connection and releases the lock
+}
+
+// openSource locks source and prepares it for syncing into its active index
+func openSource(ctx context.Context, source internal.SourceConfig, config *internal.Config) (*openedSource, error) {
+	vaultID, err := internal.EnsureVaultID(source.Path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading vault ID: %w", err)
+	}
+	lock, err := internal.LockVault(source.Path)
+	if err != nil {
+		return nil, err
+	}
+	active, err := internal.EnsureActiveIndex(source.Path, configuredIndex(config, source))
+	if err != nil {
+		lock.Unlock()
+		return nil, fmt.Errorf("error reading active index: %w", err)
+	}
+	if active.Embedder != config.Embedder.Model {
+		slog.Warn("Source is indexed with a different model than configured, syncing with the indexed one until `vector-sync reindex` switches it",
+			notescore.KeySource, source.Name, "indexed", active.Embedder, "configured", config.Embedder.Model)
+	}
+	sourceDb, err := connectIndex(config, *active, source, vaultID)
+	if err != nil {
+		lock.Unlock()
+		return nil, fmt.Errorf("error creating vector database client: %w", err)
+	}
+
+	// release closes the index connection and unlocks the vault
+	release := func() {
+		sourceDb.Close()
+		lock.Unlock()
+	}
+
+	clientTree := internal.NewTree("", source.Path)
+	clientTree.ID = vaultID
+	clientTree.Ignore = internal.NewIgnoreRules(source.Ignore)
+	serverTree := internal.NewTree("", source.Path)
+	serverTree.ID = vaultID
+	stateFile := internal.StateFileName(source.Name)
+	if err := internal.MigrateLegacyState(ctx, stateFile, serverTree, sourceDb); err != nil {
+		release()
+		return nil, fmt.Errorf("error migrating state: %w", err)
+	}
+	state, err := internal.OpenStateStore(internal.StateDir(config.Sync.StateDir, vaultID))
+	if err != nil {
+		release()
+		return nil, fmt.Errorf("error opening state store: %w", err)
+	}
+	closer := func() {
+		if err := state.Close(); err != nil {
+			slog.Error("Error closing state store", notescore.KeySource, source.Name, notescore.KeyErr, err)
+		}
+		release()
+	}
+	if err := state.ImportLegacyState(stateFile, serverTree); err != nil {
+		closer()
+		return nil, fmt.Errorf("error importing state: %w", err)
+	}
+	if err := clientTree.BuildTree(); err != nil {
+		closer()
+		return nil, fmt.Errorf("error building tree: %w", err)
+	}
+	return &openedSource{
+		clientTree: clientTree,
+		serverTree: serverTree,
+		vectorDb:   sourceDb,
+		state:      state,
+		close:      closer,
+	}, nil
+}
+
+// runningSource is a source the daemon watches and syncs
+type runningSource struct {
+	*openedSource
+	done chan struct{} // Closed once the watcher and the synchronizer have stopped
+}
+
+// startSource opens source and starts watching and syncing it until stop is
+// cancelled. Files being synced at that point finish using work.
+func startSource(stop, work context.Context, source internal.SourceConfig, config *internal.Config) (*runningSource, error) {
+	opened, err := openSource(work, source, config)
+	if err != nil {
+		return nil, err
+	}
+	watcher, err := internal.NewFileWatcher(stop, opened.clientTree, source.Name)
+	if err != nil {
+		opened.close()
+		return nil, fmt.Errorf("error creating file watcher: %w", err)
+	}
+	synchronizer := internal.NewSynchronizer(work, opened.clientTree, opened.serverTree, opened.vectorDb, config.Sync.Interval, opened.state)
+
+	running := &runningSource{openedSource: opened, done: make(chan struct{})}
+	var wg sync.WaitGroup
+	wg.Add(2)
 	go func() {
-		watcher.StartWatching()
+		defer wg.Done()
+		if err := watcher.StartWatching(); err != nil {
+			slog.Error("Error watching source, changes are only picked up after a restart", notescore.KeySource, source.Name, notescore.KeyErr, err)
+		}
 	}()
+	go func() {
+		defer wg.Done()
+		synchronizer.Start(stop, work)
+	}()
+	go func() {
+		wg.Wait()
+		close(running.done)
+	}()
+	return running, nil
+}
 
-	synchronizer := internal.NewSynchronizer(ctx, clientTree, serverTree, vectorDb)
-	go synchronizer.Start(ctx)
-
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	<-c
+// configuredIndex is the target the config file describes for source
+func configuredIndex(config *internal.Config, source internal.SourceConfig) notescore.ActiveIndex {
+	return notescore.ActiveIndex{
+		Host:      config.VectorStore.Host,
+		Index:     config.VectorStore.Index,
+		Namespace: source.Namespace,
+		Embedder:  config.Embedder.Model,
+	}
+}
 
+// connectIndex opens target for source, embedding with target's model
+func connectIndex(config *internal.Config, target notescore.ActiveIndex, source internal.SourceConfig, vaultID string) (*notescore.Vector, error) {
+	embedderConfig := config.Embedder
+	embedderConfig.Model = target.Embedder
+	vectorDb, err := notescore.NewVector(config.VectorStore.APIKey, target.Host, target.Index, notescore.NewEmbedding(embedderConfig))
+	if err != nil {
+		return nil, err
+	}
+	vectorDb.SetDimension(target.Dimension)
+	return vectorDb.ForSource(source.Name, vaultID, target.Namespace), nil
 }
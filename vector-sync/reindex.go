@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"notescore"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"time"
+	"vector-sync/internal"
+)
+
+// lockPollInterval is how often reindex retries the sync lock while a daemon
+// is still syncing the vault
+const lockPollInterval = 2 * time.Second
+
+type reindexOptions struct {
+	namespace string
+	host      string
+	index     string
+	yes       bool
+	cleanup   bool
+	abort     bool
+}
+
+// runReindex implements `vector-sync reindex`, which re-embeds every note of a
+// source with the configured embedder into a new namespace or index while the
+// current one keeps serving queries, then switches the source over to it
+func runReindex(args []string) int {
+	fs := flag.NewFlagSet("reindex", flag.ContinueOnError)
+	var options reindexOptions
+	only := fs.String("source", "", "only reindex this source")
+	fs.StringVar(&options.namespace, "namespace", "", "namespace to build (default: derived from the source and model)")
+	fs.StringVar(&options.host, "host", "", "host of a different index to build in, for a new dimension (default vector_store.host)")
+	fs.StringVar(&options.index, "target-index", "", "name of the index given by -host (default vector_store.index)")
+	fs.BoolVar(&options.yes, "yes", false, "delete the old namespace after switching without asking")
+	fs.BoolVar(&options.cleanup, "cleanup", false, "only delete the namespace replaced by the last reindex")
+	fs.BoolVar(&options.abort, "abort", false, "discard an unfinished reindex and its vectors")
+	var flags internal.Flags
+	flags.Register(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vector-sync reindex [-source name] [-namespace ns] [-host url -target-index name] [-yes | -cleanup | -abort] [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	config, err := internal.LoadConfig(flags)
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		return exitFailed
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	found := false
+	for _, source := range config.AllSources() {
+		if *only != "" && source.Name != *only {
+			continue
+		}
+		found = true
+		if err := reindexSource(ctx, source, config, options); err != nil {
+			fmt.Printf("Error reindexing source %s: %v\n", source.Name, err)
+			if ctx.Err() != nil {
+				fmt.Println("Interrupted. Run vector-sync reindex again to resume.")
+			}
+			return exitCode(err)
+		}
+	}
+	if !found {
+		fmt.Printf("Unknown source %q\n", *only)
+		return exitUsage
+	}
+	return exitOK
+}
+
+func reindexSource(ctx context.Context, source internal.SourceConfig, config *internal.Config, options reindexOptions) error {
+	vaultID, err := notescore.ReadVaultID(source.Path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("never synced, run vector-sync first")
+	}
+	if err != nil {
+		return err
+	}
+	reindexLock, err := internal.LockReindex(source.Path)
+	if err != nil {
+		return err
+	}
+	defer reindexLock.Unlock()
+
+	active, err := internal.EnsureActiveIndex(source.Path, configuredIndex(config, source))
+	if err != nil {
+		return err
+	}
+	stateDir := config.Sync.StateDir
+	reindexDir := internal.ReindexDir(stateDir, vaultID)
+	job, err := internal.LoadReindexJob(reindexDir)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case options.cleanup:
+		return cleanupPrevious(ctx, source, config, vaultID, active, true)
+	case options.abort:
+		return abortReindex(ctx, source, config, vaultID, job, active)
+	}
+
+	if job != nil && active.SameTarget(job.Target) {
+		// Interrupted right after switching; only the state is left to move
+		fmt.Printf("%s: finishing the switch to %s\n", source.Name, job.Target)
+		if err := internal.SwitchActiveIndex(source.Path, stateDir, vaultID, job, active); err != nil {
+			return err
+		}
+		return cleanupPrevious(ctx, source, config, vaultID, active, options.yes)
+	}
+
+	target, newNamespace := reindexTarget(config, source, active, options)
+	if job == nil {
+		if target.SameTarget(*active) {
+			return fmt.Errorf("%s is already active, pick another -namespace", target)
+		}
+		if err := checkDimension(ctx, config, &target, source, vaultID); err != nil {
+			return err
+		}
+		job = &internal.ReindexJob{Target: target, StartedAt: time.Now().UTC(), NewNamespace: newNamespace}
+		if err := internal.SaveReindexJob(reindexDir, job); err != nil {
+			return err
+		}
+		fmt.Printf("%s: building %s, %s keeps serving queries\n", source.Name, job.Target, active)
+	} else {
+		if options.namespace != "" && options.namespace != job.Target.Namespace || job.Target.Embedder != config.Embedder.Model {
+			return fmt.Errorf("a reindex into %s is unfinished, resume it without changing the target or run with -abort", job.Target)
+		}
+		fmt.Printf("%s: resuming the reindex into %s started %s\n", source.Name, job.Target, job.StartedAt.Local().Format(time.DateTime))
+	}
+
+	targetDb, err := connectIndex(config, job.Target, source, vaultID)
+	if err != nil {
+		return err
+	}
+	state, err := internal.OpenStateStore(reindexDir)
+	if err != nil {
+		return err
+	}
+	closed := false
+	defer func() {
+		if !closed {
+			state.Close()
+		}
+	}()
+
+	tree := internal.NewTree("", source.Path)
+	tree.ID = vaultID
+	tree.Ignore = internal.NewIgnoreRules(source.Ignore)
+	if err := buildTarget(ctx, tree, state, targetDb); err != nil {
+		return err
+	}
+
+	// Switching needs the vault to be quiet, so wait for a running daemon
+	syncLock, err := internal.LockVault(source.Path)
+	for err != nil {
+		fmt.Printf("%s: built. Waiting for the running vector-sync to stop before switching (%v)\n", source.Name, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+		syncLock, err = internal.LockVault(source.Path)
+	}
+	defer syncLock.Unlock()
+
+	// Catch up with edits made while building
+	if err := buildTarget(ctx, tree, state, targetDb); err != nil {
+		return err
+	}
+	closed = true
+	if err := state.Close(); err != nil {
+		return err
+	}
+	if err := internal.SwitchActiveIndex(source.Path, stateDir, vaultID, job, active); err != nil {
+		return err
+	}
+	fmt.Printf("%s: switched to %s. Restart note-gpt to query it.\n", source.Name, job.Target)
+
+	active, err = notescore.ReadActiveIndex(source.Path)
+	if err != nil {
+		return err
+	}
+	return cleanupPrevious(ctx, source, config, vaultID, active, options.yes)
+}
+
+// buildTarget embeds every note that is missing or out of date in the target
+func buildTarget(ctx context.Context, tree *internal.Tree, state *internal.StateStore, targetDb *notescore.Vector) error {
+	if err := tree.BuildTree(); err != nil {
+		return fmt.Errorf("error building tree: %w", err)
+	}
+	_, err := internal.BuildIndex(ctx, tree, state, targetDb, func(p internal.ReindexProgress) {
+		fmt.Printf("[%d/%d] %3.0f%% ETA %s  %s\n", p.Done, p.Total, 100*float64(p.Done)/float64(p.Total), p.ETA.Round(time.Second), p.Path)
+	})
+	return err
+}
+
+// reindexTarget is where a new reindex builds: the configured embedder in
+// the index given by flags or config, in a fresh namespace unless one is
+// given or another index can reuse the configured one. It reports whether
+// the namespace is a fresh one.
+func reindexTarget(config *internal.Config, source internal.SourceConfig, active *notescore.ActiveIndex, options reindexOptions) (notescore.ActiveIndex, bool) {
+	target := configuredIndex(config, source)
+	if options.host != "" {
+		target.Host = options.host
+	}
+	if options.index != "" {
+		target.Index = options.index
+	}
+	switch {
+	case options.namespace != "":
+		target.Namespace = options.namespace
+	case target.Host != active.Host || target.Index != active.Index:
+		// A different index can reuse the configured namespace
+	default:
+		base := source.Namespace
+		if base == "" {
+			base = source.Name
+		}
+		target.Namespace = fmt.Sprintf("%s-%s-%s", base, namespaceSlug(config.Embedder.Model), time.Now().UTC().Format("20060102150405"))
+		return target, true
+	}
+	return target, false
+}
+
+var slugPattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+func namespaceSlug(s string) string {
+	return strings.Trim(slugPattern.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
+
+// checkDimension makes sure the configured embedder fits the target index
+// and records the dimension in target
+func checkDimension(ctx context.Context, config *internal.Config, target *notescore.ActiveIndex, source internal.SourceConfig, vaultID string) error {
+	targetDb, err := connectIndex(config, *target, source, vaultID)
+	if err != nil {
+		return err
+	}
+	embedding, err := targetDb.EmbeddingDimension(ctx)
+	if err != nil {
+		return fmt.Errorf("embedder %s: %w", target.Embedder, err)
+	}
+	index, _, err := targetDb.Ping(ctx)
+	if err != nil {
+		return err
+	}
+	if index != 0 && int(index) != embedding {
+		return fmt.Errorf("index %s has dimension %d but %s makes %d-dimensional vectors; create an index with dimension %d and pass its -host and -target-index",
+			target.Index, index, target.Embedder, embedding, embedding)
+	}
+	target.Dimension = embedding
+	return nil
+}
+
+// cleanupPrevious deletes the vault's vectors from the target replaced by
+// the last reindex once the user confirms, or straight away with yes
+func cleanupPrevious(ctx context.Context, source internal.SourceConfig, config *internal.Config, vaultID string, active *notescore.ActiveIndex, yes bool) error {
+	if active.Previous == nil {
+		fmt.Printf("%s: nothing to clean up\n", source.Name)
+		return nil
+	}
+	if !yes && !confirm(fmt.Sprintf("Delete the vectors of %s from %s, which it no longer uses?", source.Name, *active.Previous)) {
+		fmt.Printf("%s: kept %s. Run vector-sync reindex -cleanup to delete it later.\n", source.Name, *active.Previous)
+		return nil
+	}
+	previousDb, err := connectIndex(config, *active.Previous, source, vaultID)
+	if err != nil {
+		return err
+	}
+	if err := internal.CleanupPrevious(ctx, source.Path, config.Sync.StateDir, vaultID, active, previousDb); err != nil {
+		return err
+	}
+	fmt.Printf("%s: deleted its vectors from %s\n", source.Name, *active.Previous)
+	return nil
+}
+
+// abortReindex deletes the vectors and state of an unfinished reindex. Only
+// a namespace the reindex named itself is emptied whole; in any other the
+// vectors recorded in the reindex state are deleted, leaving other vaults'.
+func abortReindex(ctx context.Context, source internal.SourceConfig, config *internal.Config, vaultID string, job *internal.ReindexJob, active *notescore.ActiveIndex) error {
+	if job == nil {
+		fmt.Printf("%s: no unfinished reindex\n", source.Name)
+		return nil
+	}
+	if active.SameTarget(job.Target) {
+		return fmt.Errorf("%s is already active, run reindex without -abort to finish the switch", job.Target)
+	}
+	targetDb, err := connectIndex(config, job.Target, source, vaultID)
+	if err != nil {
+		return err
+	}
+	if job.NewNamespace {
+		err = targetDb.DeleteAll(ctx)
+	} else {
+		_, err = internal.DeleteStateVectors(ctx, internal.ReindexDir(config.Sync.StateDir, vaultID), vaultID, targetDb)
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(internal.ReindexDir(config.Sync.StateDir, vaultID)); err != nil {
+		return err
+	}
+	fmt.Printf("%s: discarded the reindex into %s\n", source.Name, job.Target)
+	return nil
+}
+
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"notescore"
+	"os"
+	"time"
+	"vector-sync/internal"
+)
+
+// sourceState is what is known about a source without locking it: the notes
+// on disk and the records of its last sync
+type sourceState struct {
+	vaultID string // Empty if the vault was never synced
+	disk    *internal.Tree
+	records []internal.FileRecord
+}
+
+// readSourceState reads the state of source and builds the tree of its notes
+// on disk. It is safe to use while a daemon syncs the source.
+func readSourceState(source internal.SourceConfig, config *internal.Config) (*sourceState, error) {
+	state := &sourceState{}
+	vaultID, err := notescore.ReadVaultID(source.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		state.vaultID = vaultID
+		state.records, err = internal.ReadState(internal.StateDir(config.Sync.StateDir, vaultID))
+		if err != nil {
+			return nil, fmt.Errorf("error reading state: %w", err)
+		}
+	}
+
+	state.disk = internal.NewTree("", source.Path)
+	state.disk.ID = vaultID
+	state.disk.Ignore = internal.NewIgnoreRules(source.Ignore)
+	if err := state.disk.BuildTree(); err != nil {
+		return nil, fmt.Errorf("error building tree: %w", err)
+	}
+	return state, nil
+}
+
+// readOnlyFlags parses the flags shared by status, tree and diff and loads
+// the sources they select
+func readOnlyFlags(name string, args []string, extra func(fs *flag.FlagSet)) (*internal.Config, []internal.SourceConfig, int) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	only := fs.String("source", "", "only show this source")
+	if extra != nil {
+		extra(fs)
+	}
+	var flags internal.Flags
+	flags.Register(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, exitUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Printf("usage: vector-sync %s [-source name] [flags]\n", name)
+		return nil, nil, exitUsage
+	}
+
+	config, err := internal.LoadConfig(flags)
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		return nil, nil, exitFailed
+	}
+	sources, ok := selectSources(config, *only)
+	if !ok {
+		return nil, nil, exitUsage
+	}
+	return config, sources, exitOK
+}
+
+// runStatus implements `vector-sync status`, which shows for each source
+// whether a daemon is syncing it, its active index, the last sync and the
+// changes waiting for the next one
+func runStatus(args []string) int {
+	config, sources, code := readOnlyFlags("status", args, nil)
+	if code != exitOK {
+		return code
+	}
+
+	for i, source := range sources {
+		if i > 0 {
+			fmt.Println()
+		}
+		if err := printStatus(source, config); err != nil {
+			fmt.Printf("Error reading source %s: %v\n", source.Name, err)
+			code = exitFailed
+		}
+	}
+	return code
+}
+
+func printStatus(source internal.SourceConfig, config *internal.Config) error {
+	fmt.Printf("%s  %s\n", source.Name, source.Path)
+	state, err := readSourceState(source, config)
+	if err != nil {
+		return err
+	}
+
+	// A vault that was never synced has no lock file to look at
+	var holder string
+	if state.vaultID != "" {
+		holder, err = internal.SyncLockHolder(source.Path)
+	}
+	switch {
+	case err != nil:
+		fmt.Printf("  %-10s unknown: %v\n", "daemon", err)
+	case holder != "":
+		fmt.Printf("  %-10s running (pid %s)\n", "daemon", holder)
+	default:
+		fmt.Printf("  %-10s not running\n", "daemon")
+	}
+
+	if active, err := notescore.ReadActiveIndex(source.Path); err != nil {
+		fmt.Printf("  %-10s unknown: %v\n", "index", err)
+	} else if active != nil {
+		fmt.Printf("  %-10s %s\n", "index", active)
+	} else {
+		fmt.Printf("  %-10s %s\n", "index", configuredIndex(config, source))
+	}
+	if state.vaultID != "" {
+		job, err := internal.LoadReindexJob(internal.ReindexDir(config.Sync.StateDir, state.vaultID))
+		if err == nil && job != nil {
+			fmt.Printf("  %-10s into %s, started %s\n", "reindex", job.Target, job.StartedAt.Local().Format(time.DateTime))
+		}
+	}
+
+	if state.vaultID == "" {
+		fmt.Printf("  %-10s never\n", "synced")
+	} else {
+		var last time.Time
+		for _, record := range state.records {
+			if record.SyncedAt.After(last) {
+				last = record.SyncedAt
+			}
+		}
+		lastSync := "never"
+		if !last.IsZero() {
+			lastSync = last.Local().Format(time.DateTime)
+		}
+		fmt.Printf("  %-10s %d files, last sync %s\n", "synced", len(state.records), lastSync)
+	}
+
+	pending := internal.PendingChanges(context.Background(), state.disk, state.records)
+	counts := make(map[internal.DiffType]int)
+	for _, diff := range pending {
+		counts[diff.Type]++
+	}
+	fmt.Printf("  %-10s %d (%d added, %d modified, %d removed)\n", "pending",
+		len(pending), counts[internal.Added], counts[internal.Modified], counts[internal.Removed])
+	return nil
+}
+
+// runTree implements `vector-sync tree`, which prints the tree of the files
+// recorded in a source's sync state, or of its notes on disk with -disk
+func runTree(args []string) int {
+	var disk bool
+	config, sources, code := readOnlyFlags("tree", args, func(fs *flag.FlagSet) {
+		fs.BoolVar(&disk, "disk", false, "print the notes on disk instead of the synced state")
+	})
+	if code != exitOK {
+		return code
+	}
+
+	for _, source := range sources {
+		state, err := readSourceState(source, config)
+		if err != nil {
+			fmt.Printf("Error reading source %s: %v\n", source.Name, err)
+			return exitFailed
+		}
+		if disk {
+			state.disk.PrintTree()
+		} else {
+			internal.TreeFromRecords(source.Path, state.records).PrintTree()
+		}
+	}
+	return exitOK
+}
+
+// runDiff implements `vector-sync diff`, which lists what the next sync would
+// embed or delete without syncing anything
+func runDiff(args []string) int {
+	config, sources, code := readOnlyFlags("diff", args, nil)
+	if code != exitOK {
+		return code
+	}
+
+	for _, source := range sources {
+		state, err := readSourceState(source, config)
+		if err != nil {
+			fmt.Printf("Error reading source %s: %v\n", source.Name, err)
+			return exitFailed
+		}
+		pending := internal.PendingChanges(context.Background(), state.disk, state.records)
+		fmt.Printf("%s: %d pending\n", source.Name, len(pending))
+		for _, diff := range pending {
+			fmt.Printf("  %-9s %s\n", diff.Type, diff.Path)
+		}
+	}
+	return exitOK
+}
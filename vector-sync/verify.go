@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"notescore"
+	"os"
+	"vector-sync/internal"
+)
+
+// runVerify implements `vector-sync verify`, which compares each source's
+// files, state records and indexed vectors, and with -fix repairs the drift
+func runVerify(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	fix := fs.Bool("fix", false, "delete orphaned vectors and re-queue files with missing or stale vectors")
+	only := fs.String("source", "", "only verify this source")
+	var flags internal.Flags
+	flags.Register(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vector-sync verify [-fix] [-source name] [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	config, err := internal.LoadConfig(flags)
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		return exitFailed
+	}
+	ctx := context.Background()
+	code := exitOK
+	found := false
+	for _, source := range config.AllSources() {
+		if *only != "" && source.Name != *only {
+			continue
+		}
+		found = true
+		if err := verifySource(ctx, source, config, *fix); err != nil {
+			fmt.Printf("Error verifying source %s: %v\n", source.Name, err)
+			if code == exitOK || exitCode(err) == exitLocked {
+				code = exitCode(err)
+			}
+		}
+	}
+	if !found {
+		fmt.Printf("Unknown source %q\n", *only)
+		return exitUsage
+	}
+	return code
+}
+
+// verifySource prints the verification report of one source. It returns an
+// error when problems remain.
+func verifySource(ctx context.Context, source internal.SourceConfig, config *internal.Config, fix bool) error {
+	stateDir := config.Sync.StateDir
+	vaultID, err := notescore.ReadVaultID(source.Path)
+	if os.IsNotExist(err) {
+		fmt.Printf("%s: never synced, nothing to verify\n", source.Name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	target := configuredIndex(config, source)
+	if active, err := notescore.ReadActiveIndex(source.Path); err != nil {
+		return err
+	} else if active != nil {
+		target = *active
+	}
+	fmt.Printf("%s: vault %s, %s\n", source.Name, vaultID, target)
+
+	// Fixing rewrites the state store, which only one process may hold
+	var state *internal.StateStore
+	var records []internal.FileRecord
+	if fix {
+		lock, err := internal.LockVault(source.Path)
+		if err != nil {
+			return fmt.Errorf("%w; stop it before running verify -fix", err)
+		}
+		defer lock.Unlock()
+		state, err = internal.OpenStateStore(internal.StateDir(stateDir, vaultID))
+		if err != nil {
+			return err
+		}
+		defer state.Close()
+		records = state.Records()
+	} else {
+		records, err = internal.ReadState(internal.StateDir(stateDir, vaultID))
+		if err != nil {
+			return err
+		}
+	}
+
+	tree := internal.NewTree("", source.Path)
+	tree.ID = vaultID
+	tree.Ignore = internal.NewIgnoreRules(source.Ignore)
+	if err := tree.BuildTree(); err != nil {
+		return fmt.Errorf("error building tree: %w", err)
+	}
+
+	sourceDb, err := connectIndex(config, target, source, vaultID)
+	if err != nil {
+		return err
+	}
+	report, err := internal.Verify(ctx, tree, records, sourceDb, source.Name)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("  %d files on disk, %d state records, %d vectors\n", report.Files, report.Records, report.Vectors)
+	for _, problem := range report.Problems {
+		if problem.Kind == internal.ProblemForeign {
+			fmt.Printf("  %-9s %s\n", problem.Kind, problem.Detail)
+			continue
+		}
+		path := problem.Path
+		if path == "" {
+			path = "(unknown path)"
+		}
+		fmt.Printf("  %-9s %s: %s\n", problem.Kind, path, problem.Detail)
+	}
+
+	kinds := []string{internal.ProblemOrphan, internal.ProblemMissing, internal.ProblemStale, internal.ProblemEmbedder}
+	problems := 0
+	for _, kind := range kinds {
+		problems += report.Count(kind)
+	}
+	fmt.Printf("  %d orphaned, %d missing, %d stale, %d embedder mismatches, %d pending, %d from other vaults\n",
+		report.Count(internal.ProblemOrphan), report.Count(internal.ProblemMissing), report.Count(internal.ProblemStale),
+		report.Count(internal.ProblemEmbedder), report.Count(internal.ProblemPending), report.Count(internal.ProblemForeign))
+	if problems == 0 {
+		return nil
+	}
+	if !fix {
+		return fmt.Errorf("%d problems found, run with -fix to repair", problems)
+	}
+
+	deleted, requeued, err := internal.FixVerifyProblems(ctx, report, state, sourceDb)
+	fmt.Printf("  deleted %d orphaned vectors, re-queued %d files for the next sync\n", deleted, requeued)
+	return err
+}